@@ -0,0 +1,61 @@
+// Infinite scroll for the explore page. The pager stays in place for
+// browsers without JavaScript; otherwise the next page is fetched when
+// the pager scrolls into view and its galleries are appended.
+(function () {
+  var list = document.getElementById("explore-galleries");
+  if (!list || !("IntersectionObserver" in window) || !("fetch" in window)) {
+    return;
+  }
+  var loading = false;
+
+  function pager() {
+    return document.querySelector("nav[aria-label=Pages]");
+  }
+
+  function loadNext(observer) {
+    var current = pager();
+    var next = current && current.querySelector("li.next a[href]");
+    if (!next || loading) {
+      return;
+    }
+    loading = true;
+    fetch(next.href, { credentials: "same-origin" })
+      .then(function (response) {
+        if (!response.ok) {
+          throw new Error(response.statusText);
+        }
+        return response.text();
+      })
+      .then(function (html) {
+        var page = new DOMParser().parseFromString(html, "text/html");
+        page.querySelectorAll("#explore-galleries > .explore-gallery").forEach(function (gallery) {
+          list.appendChild(document.adoptNode(gallery));
+        });
+        var nextPager = page.querySelector("nav[aria-label=Pages]");
+        observer.unobserve(current);
+        if (nextPager) {
+          nextPager = document.adoptNode(nextPager);
+          current.parentNode.replaceChild(nextPager, current);
+          observer.observe(nextPager);
+        } else {
+          current.parentNode.removeChild(current);
+        }
+        loading = false;
+      })
+      .catch(function () {
+        // leave the pager for the visitor to follow
+        observer.disconnect();
+      });
+  }
+
+  var observer = new IntersectionObserver(function (entries) {
+    entries.forEach(function (entry) {
+      if (entry.isIntersecting) {
+        loadNext(observer);
+      }
+    });
+  }, { rootMargin: "400px" });
+  if (pager()) {
+    observer.observe(pager());
+  }
+})();
@@ -0,0 +1,45 @@
+// Drag and drop reordering for the gallery edit page. The new order is
+// saved as soon as a photo is dropped; the form can still be submitted
+// by hand if saving fails.
+(function () {
+  var form = document.getElementById("reorder-images");
+  if (!form) {
+    return;
+  }
+  var list = form.querySelector(".reorder-list");
+  var dragged = null;
+
+  function save() {
+    var request = new XMLHttpRequest();
+    request.open("POST", form.action);
+    request.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+    request.setRequestHeader("X-Requested-With", "XMLHttpRequest");
+    request.onload = function () {
+      if (request.status >= 400) {
+        alert(request.responseText);
+      }
+    };
+    request.send(new URLSearchParams(new FormData(form)).toString());
+  }
+
+  list.addEventListener("dragstart", function (event) {
+    dragged = event.target.closest("li");
+    dragged.classList.add("dragging");
+    event.dataTransfer.effectAllowed = "move";
+  });
+  list.addEventListener("dragover", function (event) {
+    var target = event.target.closest("li");
+    event.preventDefault();
+    if (!target || target === dragged) {
+      return;
+    }
+    var box = target.getBoundingClientRect();
+    var after = event.clientX > box.left + box.width / 2;
+    list.insertBefore(dragged, after ? target.nextSibling : target);
+  });
+  list.addEventListener("dragend", function () {
+    dragged.classList.remove("dragging");
+    dragged = null;
+    save();
+  });
+})();
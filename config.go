@@ -4,8 +4,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"go-web-dev/models"
+	"go-web-dev/storage"
 	"os"
 	"strconv"
+	"time"
+)
+
+const (
+	StorageBackendLocal = "local"
+	StorageBackendS3    = "s3"
 )
 
 func LoadConfig(required bool, dbEnv bool) AppConfig {
@@ -28,14 +36,11 @@ func LoadConfig(required bool, dbEnv bool) AppConfig {
 		fmt.Println("Successfully loaded configuration json...")
 	}
 
-	// anyone with access can use account
-	appConfig.Mailgun.APIKey = os.Getenv("MAILGUN_PRIVATE_KEY")
-	if appConfig.Mailgun.APIKey == "" {
-		panic(errors.New("no API key provided for mailgun client"))
-	}
-	appConfig.Dropbox.Secret = os.Getenv("DROPBOX_SECRET")
-	if appConfig.Dropbox.Secret == "" {
-		panic(errors.New("no app secret provided for dropbox"))
+	if appConfig.Storage.Backend == StorageBackendS3 {
+		appConfig.Storage.S3.SecretKey = os.Getenv("S3_SECRET_KEY")
+		if appConfig.Storage.S3.SecretKey == "" {
+			panic(errors.New("no secret key provided for S3 storage"))
+		}
 	}
 	if dbEnv {
 		appConfig.Database.Host = os.Getenv("DATABASE_HOST")
@@ -50,6 +55,20 @@ func LoadConfig(required bool, dbEnv bool) AppConfig {
 	return appConfig
 }
 
+// LoadServiceSecrets reads the secrets of the services the server
+// talks to, which maintenance commands such as -reconcile don't need.
+func (appConfig *AppConfig) LoadServiceSecrets() {
+	// anyone with access can use account
+	appConfig.Mailgun.APIKey = os.Getenv("MAILGUN_PRIVATE_KEY")
+	if appConfig.Mailgun.APIKey == "" {
+		panic(errors.New("no API key provided for mailgun client"))
+	}
+	appConfig.Dropbox.Secret = os.Getenv("DROPBOX_SECRET")
+	if appConfig.Dropbox.Secret == "" {
+		panic(errors.New("no app secret provided for dropbox"))
+	}
+}
+
 type AppConfig struct {
 	Port     int            `json:"port"`
 	Env      string         `json:"env"`
@@ -58,6 +77,9 @@ type AppConfig struct {
 	Mailgun  MailgunConfig  `json:"mailgun"`
 	Dropbox  OAuthConfig    `json:"dropbox"`
 	Database PostgresConfig `json:"database"`
+	Images   ImageConfig    `json:"images"`
+	Storage  StorageConfig  `json:"storage"`
+	Trash    TrashConfig    `json:"trash"`
 }
 
 func (appConfig *AppConfig) IsProd() bool {
@@ -71,6 +93,9 @@ func DefaultAppConfig() AppConfig {
 		Pepper:   "dev-pepper",
 		HMACKey:  "dev-hmac-key",
 		Database: DefaultPostgresConfig(),
+		Images:   DefaultImageConfig(),
+		Storage:  DefaultStorageConfig(),
+		Trash:    DefaultTrashConfig(),
 	}
 }
 
@@ -117,3 +142,105 @@ func DefaultPostgresConfig() PostgresConfig {
 		Name:     "fakeoku",
 	}
 }
+
+type ImageConfig struct {
+	// long edge, in pixels, of the resized copies made for each upload
+	VariantSizes    []int `json:"variant_sizes"`
+	MaxFileBytes    int64 `json:"max_file_bytes"`
+	MaxRequestBytes int64 `json:"max_request_bytes"`
+	MaxPixels       int64 `json:"max_pixels"`
+	// limits applied to the contents of uploaded ZIP archives
+	MaxArchiveEntries int   `json:"max_archive_entries"`
+	MaxArchiveBytes   int64 `json:"max_archive_bytes"`
+}
+
+func (imgConfig ImageConfig) Limits() models.ImageLimits {
+	return models.ImageLimits{
+		MaxBytes:          imgConfig.MaxFileBytes,
+		MaxPixels:         imgConfig.MaxPixels,
+		MaxArchiveEntries: imgConfig.MaxArchiveEntries,
+		MaxArchiveBytes:   imgConfig.MaxArchiveBytes,
+	}
+}
+
+func DefaultImageConfig() ImageConfig {
+	return ImageConfig{
+		VariantSizes:      []int{320, 800, 1600},
+		MaxFileBytes:      32 << 20,  // 32 megabytes
+		MaxRequestBytes:   512 << 20, // 512 megabytes
+		MaxPixels:         50000000,  // 50 megapixels
+		MaxArchiveEntries: 2000,
+		MaxArchiveBytes:   4 << 30, // 4 gigabytes
+	}
+}
+
+type TrashConfig struct {
+	// days deleted galleries are kept in the trash before being purged
+	// along with their images, zero keeps them until purged by hand
+	RetentionDays int `json:"retention_days"`
+	// minutes between checks for galleries to purge
+	PurgeIntervalMinutes int `json:"purge_interval_minutes"`
+}
+
+func (trashConfig TrashConfig) Retention() time.Duration {
+	return time.Duration(trashConfig.RetentionDays) * 24 * time.Hour
+}
+
+func (trashConfig TrashConfig) PurgeInterval() time.Duration {
+	if trashConfig.PurgeIntervalMinutes <= 0 {
+		return time.Hour
+	}
+	return time.Duration(trashConfig.PurgeIntervalMinutes) * time.Minute
+}
+
+func DefaultTrashConfig() TrashConfig {
+	return TrashConfig{
+		RetentionDays:        30,
+		PurgeIntervalMinutes: 60,
+	}
+}
+
+type StorageConfig struct {
+	// Backend is either local or s3
+	Backend  string   `json:"backend"`
+	LocalDir string   `json:"local_dir"`
+	S3       S3Config `json:"s3"`
+}
+
+type S3Config struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// when set, images are served through presigned URLs valid this long
+	PresignSeconds int `json:"presign_seconds"`
+}
+
+func (storageConfig StorageConfig) Storage() (storage.Storage, error) {
+	switch storageConfig.Backend {
+	case StorageBackendS3:
+		return storage.NewS3(storage.S3Config{
+			Endpoint:   storageConfig.S3.Endpoint,
+			Region:     storageConfig.S3.Region,
+			Bucket:     storageConfig.S3.Bucket,
+			AccessKey:  storageConfig.S3.AccessKey,
+			SecretKey:  storageConfig.S3.SecretKey,
+			PresignTTL: time.Duration(storageConfig.S3.PresignSeconds) * time.Second,
+		})
+	case StorageBackendLocal, "":
+		if storageConfig.LocalDir == "" {
+			return storage.NewLocal("images"), nil
+		}
+		return storage.NewLocal(storageConfig.LocalDir), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", storageConfig.Backend)
+	}
+}
+
+func DefaultStorageConfig() StorageConfig {
+	return StorageConfig{
+		Backend:  StorageBackendLocal,
+		LocalDir: "images",
+	}
+}
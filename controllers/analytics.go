@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultAnalyticsDays is the period analytics cover unless
+	// another one is picked
+	defaultAnalyticsDays = 30
+	// topImagesSize is the number of most viewed images listed
+	topImagesSize = 10
+)
+
+// analyticsPeriods are the numbers of days analytics may cover.
+var analyticsPeriods = []int{7, 30, 90, 365}
+
+type AnalyticsForm struct {
+	Days int `schema:"days"`
+}
+
+// AnalyticsPage shows how a gallery was visited over the last Days
+// days.
+type AnalyticsPage struct {
+	*models.Gallery
+	Days      int
+	Periods   []int
+	Daily     []models.DailyStats
+	Total     models.DailyStats
+	TopImages []models.ImageViews
+}
+
+// Recent lists the daily stats newest first.
+func (page *AnalyticsPage) Recent() []models.DailyStats {
+	recent := make([]models.DailyStats, len(page.Daily))
+	for i, day := range page.Daily {
+		recent[len(recent)-1-i] = day
+	}
+	return recent
+}
+
+// GET /galleries/:id/analytics
+//
+// Shows the owner how often the gallery was viewed and downloaded, and
+// which of its images were opened most.
+func (galleryController *GalleryController) Analytics(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+	page := &AnalyticsPage{Gallery: gallery, Periods: analyticsPeriods}
+	viewData.Yield = page
+	from, to := galleryController.analyticsPeriod(r, page)
+
+	page.Daily, err = galleryController.analyticsService.Daily(gallery.ID, from, to)
+	if err == nil {
+		page.TopImages, err = galleryController.analyticsService.TopImages(gallery.ID, from, to, topImagesSize)
+	}
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.AnalyticsView.Render(w, r, viewData)
+		return
+	}
+	for _, day := range page.Daily {
+		page.Total.Add(day)
+	}
+	galleryController.AnalyticsView.Render(w, r, viewData)
+}
+
+// GET /galleries/:id/analytics.csv
+//
+// Exports the daily stats shown on the analytics page.
+func (galleryController *GalleryController) AnalyticsCSV(w http.ResponseWriter, r *http.Request) {
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+	page := &AnalyticsPage{Gallery: gallery}
+	from, to := galleryController.analyticsPeriod(r, page)
+	days, err := galleryController.analyticsService.Daily(gallery.ID, from, to)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName(gallery.Title, "gallery") + " analytics.csv",
+	}))
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"date", "views", "visitors", "image_views", "downloads"})
+	for _, day := range days {
+		writer.Write([]string{
+			day.Day.Format("2006-01-02"),
+			strconv.Itoa(day.Views),
+			strconv.Itoa(day.Visitors),
+			strconv.Itoa(day.ImageViews),
+			strconv.Itoa(day.Downloads),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+// analyticsPeriod sets the number of days the page covers from the
+// request, and returns the first and last of them.
+func (galleryController *GalleryController) analyticsPeriod(r *http.Request, page *AnalyticsPage) (time.Time, time.Time) {
+	var form AnalyticsForm
+	page.Days = defaultAnalyticsDays
+	if err := parseURLParams(r, &form); err == nil {
+		for _, days := range analyticsPeriods {
+			if form.Days == days {
+				page.Days = days
+			}
+		}
+	}
+	to := time.Now().UTC()
+	return to.AddDate(0, 0, 1-page.Days), to
+}
+
+// recordEvent counts what a visitor did with the gallery. Those who
+// work on the gallery are left out, so that only its audience counts.
+func (galleryController *GalleryController) recordEvent(r *http.Request, gallery *models.Gallery, kind string, imageID uint) {
+	if gallery.CanUpload() {
+		return
+	}
+	event := models.Event{
+		Kind:      kind,
+		GalleryID: gallery.ID,
+		ImageID:   imageID,
+		Visitor:   visitor(r),
+	}
+	if err := galleryController.analyticsService.Record(&event); err != nil {
+		log.Println(err)
+	}
+}
+
+// visitor identifies who made the request: signed in users by their
+// ID, and everyone else by their address and browser.
+func visitor(r *http.Request) string {
+	if user := context.User(r.Context()); user != nil {
+		return "user:" + strconv.Itoa(int(user.ID))
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host + " " + r.UserAgent()
+}
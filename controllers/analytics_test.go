@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubAnalyticsService keeps the events it records and reports one
+// view a day.
+type stubAnalyticsService struct {
+	models.AnalyticsService
+	events   []models.Event
+	from, to time.Time
+}
+
+func (stub *stubAnalyticsService) Record(event *models.Event) error {
+	stub.events = append(stub.events, *event)
+	return nil
+}
+
+func (stub *stubAnalyticsService) Daily(galleryID uint, from, to time.Time) ([]models.DailyStats, error) {
+	stub.from, stub.to = from, to
+	return []models.DailyStats{
+		{Day: time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC), Views: 3, Visitors: 2, Downloads: 1},
+		{Day: time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC), Views: 1, Visitors: 1},
+	}, nil
+}
+
+func (stub *stubAnalyticsService) TopImages(galleryID uint, from, to time.Time, limit int) ([]models.ImageViews, error) {
+	img := models.ImageViews{Image: models.Image{GalleryID: galleryID, Filename: validFilename}, Views: 5}
+	img.ID = 7
+	return []models.ImageViews{img}, nil
+}
+
+func TestAnalytics(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	// visitors are counted, the owner isn't, and images only when they
+	// are opened rather than loaded on the page
+	serveAs(r, http.MethodGet, "/galleries/1", "", 0)
+	serveAs(r, http.MethodGet, "/galleries/1", "", 1)
+	serveAs(r, http.MethodGet, "/galleries/1/download", "", 2)
+	serveAs(r, http.MethodGet, "/images/galleries/1/"+validFilename, "", 0)
+	serveAs(r, http.MethodGet, "/images/galleries/1/"+validFilename+"?view=1", "", 0)
+	kinds := make([]string, len(stubs.analytics.events))
+	for i, event := range stubs.analytics.events {
+		kinds[i] = event.Kind
+		if event.GalleryID != 1 || event.Visitor == "" {
+			t.Errorf("Expected events of gallery 1 with a visitor. Received %+v", event)
+		}
+	}
+	if strings.Join(kinds, ",") != "view,download,image_view" {
+		t.Errorf("Expected a view, a download and an image view. Received %v", kinds)
+	}
+	if stubs.analytics.events[1].Visitor != "user:2" {
+		t.Errorf("Expected signed in visitors to be told apart by ID. Received %q", stubs.analytics.events[1].Visitor)
+	}
+
+	if w := serveAs(r, http.MethodGet, "/galleries/1/analytics", "", 2); w.Code != http.StatusNotFound {
+		t.Errorf("Expected analytics to be hidden from visitors. Received %d", w.Code)
+	}
+	w := serveAs(r, http.MethodGet, "/galleries/1/analytics?days=7", "", 1)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the owner to see analytics. Received %d", w.Code)
+	}
+	if days := stubs.analytics.to.Sub(stubs.analytics.from); days != 6*24*time.Hour {
+		t.Errorf("Expected 7 days of analytics. Received %v", days)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		`<h3>4</h3>views`,
+		`<h3>1</h3>downloads`,
+		`href="/galleries/1#image-7"`,
+		`href="/galleries/1/analytics.csv?days=7"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the page to contain %s. Received %s", expected, body)
+		}
+	}
+
+	w = serveAs(r, http.MethodGet, "/galleries/1/analytics.csv", "", 1)
+	if w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("Expected a CSV file. Received %q", w.Header().Get("Content-Type"))
+	}
+	expected := "date,views,visitors,image_views,downloads\n2021-06-01,3,2,0,1\n2021-06-02,1,1,0,0\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected %q. Received %q", expected, w.Body.String())
+	}
+	if days := stubs.analytics.to.Sub(stubs.analytics.from); days != 29*24*time.Hour {
+		t.Errorf("Expected 30 days by default. Received %v", days)
+	}
+}
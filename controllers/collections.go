@@ -0,0 +1,338 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// NewCollectionController creates a controller for the collections
+// users gather images from galleries into.
+func NewCollectionController(collectionService models.CollectionService) *CollectionController {
+	return &CollectionController{
+		IndexView:         views.NewView("bootstrap", "collections/index"),
+		ShowView:          views.NewView("bootstrap", "collections/show"),
+		collectionService: collectionService,
+	}
+}
+
+type CollectionController struct {
+	IndexView         *views.View
+	ShowView          *views.View
+	collectionService models.CollectionService
+}
+
+type CollectionForm struct {
+	Name string `schema:"name"`
+}
+
+// CollectImageForm picks the collection to add an image to or remove
+// it from. Images added without a CollectionID go to the user's
+// collection called Name, which is created if needed, or to their
+// favorites when Name is empty too.
+type CollectImageForm struct {
+	CollectionID uint   `schema:"collection_id"`
+	Name         string `schema:"name"`
+}
+
+// CollectionPage lists the images of a collection.
+type CollectionPage struct {
+	Collection *models.Collection
+	Items      []models.CollectionItem
+}
+
+// GET /collections
+func (collectionController *CollectionController) Index(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	user := context.User(r.Context())
+	collections, err := collectionController.collectionService.ByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	viewData.Yield = collections
+	collectionController.IndexView.Render(w, r, viewData)
+}
+
+// POST /collections
+func (collectionController *CollectionController) Create(w http.ResponseWriter, r *http.Request) {
+	var form CollectionForm
+	if err := parseForm(r, &form); err != nil {
+		redirectError(w, r, "/collections", err)
+		return
+	}
+	collection := models.Collection{
+		UserID: context.User(r.Context()).ID,
+		Name:   form.Name,
+	}
+	if err := collectionController.collectionService.Create(&collection); err != nil {
+		redirectError(w, r, "/collections", err)
+		return
+	}
+	http.Redirect(w, r, collection.Path(), http.StatusFound)
+}
+
+// GET /collections/:id
+func (collectionController *CollectionController) Show(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	collection, err := collectionController.fetchCollection(w, r)
+	if err != nil {
+		return
+	}
+	items, err := collectionController.collectionService.Items(collection.ID, collection.UserID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	viewData.Yield = &CollectionPage{Collection: collection, Items: items}
+	collectionController.ShowView.Render(w, r, viewData)
+}
+
+// POST /collections/:id/update
+func (collectionController *CollectionController) Update(w http.ResponseWriter, r *http.Request) {
+	var form CollectionForm
+	collection, err := collectionController.fetchCollection(w, r)
+	if err != nil {
+		return
+	}
+	if err := parseForm(r, &form); err != nil {
+		redirectError(w, r, collection.Path(), err)
+		return
+	}
+	collection.Name = form.Name
+	if err := collectionController.collectionService.Update(collection); err != nil {
+		redirectError(w, r, collection.Path(), err)
+		return
+	}
+	http.Redirect(w, r, collection.Path(), http.StatusFound)
+}
+
+// POST /collections/:id/delete
+//
+// Deletes the collection, leaving the images in their galleries.
+func (collectionController *CollectionController) Delete(w http.ResponseWriter, r *http.Request) {
+	collection, err := collectionController.fetchCollection(w, r)
+	if err != nil {
+		return
+	}
+	if err := collectionController.collectionService.Delete(collection.ID); err != nil {
+		redirectError(w, r, collection.Path(), err)
+		return
+	}
+	views.RedirectAlert(w, r, "/collections", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: collection.Name + " was deleted.",
+	})
+}
+
+// POST /collections/:id/images/:image_id/remove
+func (collectionController *CollectionController) RemoveImage(w http.ResponseWriter, r *http.Request) {
+	collection, err := collectionController.fetchCollection(w, r)
+	if err != nil {
+		return
+	}
+	imageID, err := strconv.Atoi(mux.Vars(r)["image_id"])
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	err = collectionController.collectionService.RemoveImage(collection.ID, uint(imageID))
+	if err != nil {
+		redirectError(w, r, collection.Path(), err)
+		return
+	}
+	http.Redirect(w, r, collection.Path(), http.StatusFound)
+}
+
+// fetchCollection looks up the collection of the request. Collections
+// are personal, so those of other users are reported as not found.
+func (collectionController *CollectionController) fetchCollection(w http.ResponseWriter, r *http.Request) (*models.Collection, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return nil, err
+	}
+	return fetchOwnCollection(w, r, collectionController.collectionService, uint(id))
+}
+
+// fetchOwnCollection looks up the current user's collection by ID.
+func fetchOwnCollection(w http.ResponseWriter, r *http.Request, collectionService models.CollectionService, id uint) (*models.Collection, error) {
+	collection, err := collectionService.ByID(id)
+	if err == nil && collection.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return collection, nil
+	case models.ErrNotFound:
+		http.Error(w, "Collection not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+// redirectError redirects to urlStr with err as the alert.
+func redirectError(w http.ResponseWriter, r *http.Request, urlStr string, err error) {
+	var viewData views.Data
+	viewData.SetAlert(err)
+	views.RedirectAlert(w, r, urlStr, http.StatusFound, *viewData.Alert)
+}
+
+// CollectionsWith lists the viewer's collections holding img.
+func (page *GalleryPage) CollectionsWith(img models.Image) []models.Collection {
+	var result []models.Collection
+	for _, collection := range page.Collections {
+		if page.holds(collection, img) {
+			result = append(result, collection)
+		}
+	}
+	return result
+}
+
+// CollectionsWithout lists the viewer's collections img can be added
+// to.
+func (page *GalleryPage) CollectionsWithout(img models.Image) []models.Collection {
+	var result []models.Collection
+	for _, collection := range page.Collections {
+		if !page.holds(collection, img) {
+			result = append(result, collection)
+		}
+	}
+	return result
+}
+
+func (page *GalleryPage) holds(collection models.Collection, img models.Image) bool {
+	for _, id := range page.collected[img.ID] {
+		if id == collection.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// FavoriteCount is the number of users who collected img, which is
+// only known to the gallery's owner.
+func (page *GalleryPage) FavoriteCount(img models.Image) int {
+	return page.favoriteCounts[img.ID]
+}
+
+// POST /galleries/:id/images/:filename/collect
+// POST /g/:public_id/images/:filename/collect
+func (galleryController *GalleryController) CollectImage(w http.ResponseWriter, r *http.Request) {
+	galleryController.updateCollection(w, r, true)
+}
+
+// POST /galleries/:id/images/:filename/uncollect
+// POST /g/:public_id/images/:filename/uncollect
+func (galleryController *GalleryController) UncollectImage(w http.ResponseWriter, r *http.Request) {
+	galleryController.updateCollection(w, r, false)
+}
+
+// updateCollection adds the image of the request to one of the current
+// user's collections, or removes it when add is false.
+func (galleryController *GalleryController) updateCollection(w http.ResponseWriter, r *http.Request, add bool) {
+	var form CollectImageForm
+	gallery, err := galleryController.fetchVisibleGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+
+	filename := mux.Vars(r)["filename"]
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		galleryController.redirectToImage(w, r, gallery, img, err)
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		galleryController.redirectToImage(w, r, gallery, img, err)
+		return
+	}
+	var collection *models.Collection
+	if form.CollectionID != 0 {
+		collection, err = fetchOwnCollection(w, r, galleryController.collectionService, form.CollectionID)
+		if err != nil {
+			return
+		}
+	} else if add {
+		collection, err = galleryController.findOrCreateCollection(context.User(r.Context()), form.Name)
+		if err != nil {
+			galleryController.redirectToImage(w, r, gallery, img, err)
+			return
+		}
+	} else {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+
+	if add {
+		err = galleryController.collectionService.AddImage(collection.ID, img.ID)
+	} else {
+		err = galleryController.collectionService.RemoveImage(collection.ID, img.ID)
+	}
+	galleryController.redirectToImage(w, r, gallery, img, err)
+}
+
+// findOrCreateCollection returns the user's collection called name, or
+// their favorites when name is blank, creating it if needed.
+func (galleryController *GalleryController) findOrCreateCollection(user *models.User, name string) (*models.Collection, error) {
+	collection := models.Collection{UserID: user.ID, Name: name}
+	if strings.TrimSpace(name) == "" {
+		collection.Name = models.DefaultCollectionName
+	}
+	existing, err := galleryController.collectionService.ByName(user.ID, collection.Name)
+	if err != models.ErrNotFound {
+		return existing, err
+	}
+	if err := galleryController.collectionService.Create(&collection); err != nil {
+		return nil, err
+	}
+	return &collection, nil
+}
+
+// loadCollections fills in the viewer's collections, and which of them
+// hold each of the gallery's images. Owners also see how many users
+// collected each image.
+func (galleryController *GalleryController) loadCollections(page *GalleryPage) {
+	imageIDs := make([]uint, len(page.Images))
+	for i := range page.Images {
+		imageIDs[i] = page.Images[i].ID
+	}
+	var err error
+	if page.Viewer != nil {
+		page.Collections, err = galleryController.collectionService.ByUserID(page.Viewer.ID)
+		if err == nil {
+			page.collected, err = galleryController.collectionService.Containing(page.Viewer.ID, imageIDs...)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	if page.CanManage() {
+		page.favoriteCounts, err = galleryController.collectionService.FavoriteCounts(imageIDs...)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
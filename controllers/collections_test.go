@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// stubCollectionService keeps collections by ID and the IDs of the
+// images in each.
+type stubCollectionService struct {
+	models.CollectionService
+	collections map[uint]*models.Collection
+	images      map[uint][]uint
+	items       []models.CollectionItem
+	counts      map[uint]int
+}
+
+func (stub *stubCollectionService) Create(collection *models.Collection) error {
+	if stub.collections == nil {
+		stub.collections = make(map[uint]*models.Collection)
+	}
+	collection.ID = uint(len(stub.collections) + 1)
+	stub.collections[collection.ID] = collection
+	return nil
+}
+
+func (stub *stubCollectionService) ByID(id uint) (*models.Collection, error) {
+	collection, ok := stub.collections[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return collection, nil
+}
+
+func (stub *stubCollectionService) ByName(userID uint, name string) (*models.Collection, error) {
+	for _, collection := range stub.collections {
+		if collection.UserID == userID && strings.EqualFold(collection.Name, name) {
+			return collection, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (stub *stubCollectionService) ByUserID(userID uint) ([]models.Collection, error) {
+	var result []models.Collection
+	for _, collection := range stub.collections {
+		if collection.UserID == userID {
+			result = append(result, *collection)
+		}
+	}
+	return result, nil
+}
+
+func (stub *stubCollectionService) Items(collectionID, viewerID uint) ([]models.CollectionItem, error) {
+	return stub.items, nil
+}
+
+func (stub *stubCollectionService) AddImage(collectionID, imageID uint) error {
+	if stub.images == nil {
+		stub.images = make(map[uint][]uint)
+	}
+	stub.images[collectionID] = append(stub.images[collectionID], imageID)
+	return nil
+}
+
+func (stub *stubCollectionService) RemoveImage(collectionID, imageID uint) error {
+	delete(stub.images, collectionID)
+	return nil
+}
+
+func (stub *stubCollectionService) Containing(userID uint, imageIDs ...uint) (map[uint][]uint, error) {
+	result := make(map[uint][]uint)
+	for collectionID, ids := range stub.images {
+		for _, id := range ids {
+			result[id] = append(result[id], collectionID)
+		}
+	}
+	return result, nil
+}
+
+func (stub *stubCollectionService) FavoriteCounts(imageIDs ...uint) (map[uint]int, error) {
+	return stub.counts, nil
+}
+
+func TestCollectImage(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	collections := stubs.collections
+	collections.Create(&models.Collection{UserID: 3, Name: "Someone else's"})
+	img := models.Image{GalleryID: 1, Filename: validFilename}
+	stubs.images.images = []models.Image{img}
+	collections.counts = map[uint]int{img.ID: 4}
+
+	// images saved without picking a collection go to the favorites
+	w := serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/collect", "collection_id=0", 2)
+	favorites, err := collections.ByName(2, models.DefaultCollectionName)
+	if w.Code != http.StatusFound || err != nil || len(collections.images[favorites.ID]) != 1 {
+		t.Fatalf("Expected the image to be added to new favorites. Received %d, %v", w.Code, collections.images)
+	}
+	serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/collect", "collection_id=0&name=favorites", 2)
+	if len(collections.collections) != 2 || len(collections.images[favorites.ID]) != 2 {
+		t.Errorf("Expected the existing favorites to be reused. Received %v", collections.images)
+	}
+
+	if w := serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/collect", "collection_id=1", 2); w.Code != http.StatusNotFound || len(collections.images[1]) != 0 {
+		t.Errorf("Expected another user's collection to be rejected. Received %d", w.Code)
+	}
+
+	body := serveAs(r, http.MethodGet, "/galleries/1", "", 2).Body.String()
+	if !strings.Contains(body, "/uncollect") || strings.Contains(body, "Collected by") {
+		t.Errorf("Expected a remove button without favorite counts. Received %s", body)
+	}
+	if body := serveAs(r, http.MethodGet, "/galleries/1", "", 1).Body.String(); !strings.Contains(body, "Collected by 4 people") {
+		t.Errorf("Expected the owner to see favorite counts. Received %s", body)
+	}
+
+	serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/uncollect", "collection_id=2", 2)
+	if len(collections.images[favorites.ID]) != 0 {
+		t.Errorf("Expected the image to be removed. Received %v", collections.images)
+	}
+}
+
+func TestCollectionPages(t *testing.T) {
+	testingGalleryRouter(t)
+	collections := &stubCollectionService{}
+	collections.Create(&models.Collection{UserID: 2, Name: "Birds"})
+	item := models.CollectionItem{
+		Image:   models.Image{GalleryID: 1, Filename: validFilename},
+		Gallery: models.Gallery{Title: "Coast", Visibility: models.VisibilityUnlisted, PublicID: "AAAA"},
+	}
+	collections.items = []models.CollectionItem{item}
+	collectionController := NewCollectionController(collections)
+	r := mux.NewRouter()
+	r.HandleFunc("/collections", collectionController.Index).Methods("GET")
+	r.HandleFunc("/collections/{id:[0-9]+}", collectionController.Show).Methods("GET")
+
+	if body := serveAs(r, http.MethodGet, "/collections", "", 2).Body.String(); !strings.Contains(body, "/collections/1") {
+		t.Errorf("Expected the collection to be listed. Received %s", body)
+	}
+	if body := serveAs(r, http.MethodGet, "/collections/1", "", 2).Body.String(); !strings.Contains(body, `href="/g/AAAA"`) {
+		t.Errorf("Expected the image to link to its gallery. Received %s", body)
+	}
+	if w := serveAs(r, http.MethodGet, "/collections/1", "", 3); w.Code != http.StatusNotFound {
+		t.Errorf("Expected other users' collections to be hidden. Received %d", w.Code)
+	}
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type CommentForm struct {
+	Body string `schema:"body"`
+}
+
+type HideCommentForm struct {
+	Hidden bool `schema:"hidden"`
+}
+
+// GalleryPage is what the show page of a gallery renders: the gallery
+// and the user viewing it, if they are signed in.
+type GalleryPage struct {
+	*models.Gallery
+	Viewer *models.User
+	// Collections are the viewer's, which the images can be added to
+	Collections    []models.Collection
+	collected      map[uint][]uint
+	favoriteCounts map[uint]int
+}
+
+// CanComment reports whether the viewer may comment on the gallery's
+// images. Visitors have to sign in first, and galleries viewed through
+// share links aren't open to comments.
+func (page *GalleryPage) CanComment() bool {
+	return page.Viewer != nil && page.ShareLink == nil && !page.CommentsDisabled
+}
+
+// CanDeleteComment reports whether the viewer may delete comment,
+// which is left to its author and whoever manages the gallery.
+func (page *GalleryPage) CanDeleteComment(comment models.Comment) bool {
+	if page.CanManage() {
+		return true
+	}
+	return page.Viewer != nil && page.Viewer.ID == comment.UserID
+}
+
+// POST /galleries/:id/images/:filename/comments
+// POST /g/:public_id/images/:filename/comments
+func (galleryController *GalleryController) CreateComment(w http.ResponseWriter, r *http.Request) {
+	var form CommentForm
+	gallery, err := galleryController.fetchVisibleGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+
+	filename := mux.Vars(r)["filename"]
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		galleryController.redirectToImage(w, r, gallery, img, err)
+		return
+	}
+	if gallery.CommentsDisabled {
+		galleryController.redirectToImage(w, r, gallery, img, models.ErrCommentsDisabled)
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		galleryController.redirectToImage(w, r, gallery, img, err)
+		return
+	}
+	comment := models.Comment{
+		GalleryID: gallery.ID,
+		ImageID:   img.ID,
+		UserID:    context.User(r.Context()).ID,
+		Body:      form.Body,
+	}
+	err = galleryController.commentService.Create(&comment)
+	galleryController.redirectToImage(w, r, gallery, img, err)
+}
+
+// POST /galleries/:id/comments/:comment_id/hide
+//
+// Hides the comment from everyone but those managing the gallery, or
+// shows it again when hidden is false.
+func (galleryController *GalleryController) HideComment(w http.ResponseWriter, r *http.Request) {
+	var form HideCommentForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+	comment, err := galleryController.fetchComment(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		galleryController.redirectToComment(w, r, gallery, comment, err)
+		return
+	}
+	err = galleryController.commentService.SetHidden(comment.ID, form.Hidden)
+	galleryController.redirectToComment(w, r, gallery, comment, err)
+}
+
+// POST /galleries/:id/comments/:comment_id/delete
+func (galleryController *GalleryController) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	gallery, err := galleryController.fetchGallery(w, r)
+	if err != nil {
+		return
+	}
+	comment, err := galleryController.fetchComment(w, r, gallery)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	page := GalleryPage{Gallery: gallery, Viewer: user}
+	if !page.CanDeleteComment(*comment) {
+		if gallery.VisibleTo(user, true) {
+			http.Error(w, "You don't have permission to do that", http.StatusForbidden)
+		} else {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	err = galleryController.commentService.Delete(comment.ID)
+	galleryController.redirectToComment(w, r, gallery, comment, err)
+}
+
+// fetchComment looks up the comment of the request, which has to be on
+// one of the gallery's images.
+func (galleryController *GalleryController) fetchComment(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Comment, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["comment_id"])
+	if err != nil {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, err
+	}
+	comment, err := galleryController.commentService.ByID(uint(id))
+	if err == nil && comment.GalleryID != gallery.ID {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return comment, nil
+	case models.ErrNotFound:
+		http.Error(w, "Comment not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+// loadComments fills in the comments on the gallery's images. Hidden
+// comments are only loaded for those managing the gallery.
+func (galleryController *GalleryController) loadComments(gallery *models.Gallery) {
+	imageIDs := make([]uint, len(gallery.Images))
+	for i := range gallery.Images {
+		imageIDs[i] = gallery.Images[i].ID
+	}
+	comments, err := galleryController.commentService.ByImageIDs(gallery.CanManage(), imageIDs...)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for i := range gallery.Images {
+		gallery.Images[i].Comments = comments[gallery.Images[i].ID]
+	}
+}
+
+// redirectToComment goes back to the image the comment is on.
+func (galleryController *GalleryController) redirectToComment(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, comment *models.Comment, err error) {
+	img := models.Image{}
+	img.ID = comment.ImageID
+	galleryController.redirectToImage(w, r, gallery, &img, err)
+}
+
+// redirectToImage goes back to the image on the gallery's page, with
+// err as the alert if the action failed.
+func (galleryController *GalleryController) redirectToImage(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, img *models.Image, err error) {
+	url := gallery.Path()
+	if img != nil {
+		url += "#image-" + strconv.Itoa(int(img.ID))
+	}
+	if err != nil {
+		redirectError(w, r, url, err)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
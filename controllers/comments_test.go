@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// stubCommentService keeps comments by ID.
+type stubCommentService struct {
+	models.CommentService
+	comments map[uint]models.Comment
+	created  []models.Comment
+	deleted  []uint
+}
+
+func (stub *stubCommentService) Create(comment *models.Comment) error {
+	stub.created = append(stub.created, *comment)
+	return nil
+}
+
+func (stub *stubCommentService) ByID(id uint) (*models.Comment, error) {
+	comment, ok := stub.comments[id]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	return &comment, nil
+}
+
+func (stub *stubCommentService) ByImageIDs(includeHidden bool, imageIDs ...uint) (map[uint][]models.Comment, error) {
+	result := make(map[uint][]models.Comment)
+	for _, comment := range stub.comments {
+		if includeHidden || !comment.Hidden {
+			result[comment.ImageID] = append(result[comment.ImageID], comment)
+		}
+	}
+	return result, nil
+}
+
+func (stub *stubCommentService) SetHidden(id uint, hidden bool) error {
+	comment := stub.comments[id]
+	comment.Hidden = hidden
+	stub.comments[id] = comment
+	return nil
+}
+
+func (stub *stubCommentService) Delete(id uint) error {
+	stub.deleted = append(stub.deleted, id)
+	return nil
+}
+
+func TestComments(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	img := models.Image{GalleryID: 1, Filename: validFilename, ContentType: "image/jpeg"}
+	img.ID = 5
+	stubs.images.images = []models.Image{img}
+	comment := models.Comment{GalleryID: 1, ImageID: 5, UserID: 2, Body: "Lovely light", AuthorName: "Commenter"}
+	comment.ID = 9
+	stubs.comments.comments = map[uint]models.Comment{comment.ID: comment}
+
+	// authors see their comments with a delete button, but only the
+	// owner may hide them
+	w := serveAs(r, http.MethodGet, "/galleries/1", "", 2)
+	if body := w.Body.String(); !strings.Contains(body, "Lovely light") || !strings.Contains(body, "/comments/9/delete") || strings.Contains(body, "/comments/9/hide") {
+		t.Errorf("Expected the comment with a delete button only. Received %s", body)
+	}
+	if w := serveAs(r, http.MethodPost, "/galleries/1/comments/9/hide", "hidden=true", 2); w.Code != http.StatusNotFound || stubs.comments.comments[9].Hidden {
+		t.Errorf("Expected hiding by a visitor to be rejected. Received %d", w.Code)
+	}
+	if w := serveAs(r, http.MethodPost, "/galleries/1/comments/9/hide", "hidden=true", 1); w.Code != http.StatusFound || !stubs.comments.comments[9].Hidden {
+		t.Errorf("Expected the owner to hide the comment. Received %d", w.Code)
+	}
+	if body := serveAs(r, http.MethodGet, "/galleries/1", "", 3).Body.String(); strings.Contains(body, "Lovely light") {
+		t.Errorf("Expected hidden comments to be left out for visitors. Received %s", body)
+	}
+
+	if w := serveAs(r, http.MethodPost, "/galleries/1/comments/9/delete", "", 3); w.Code != http.StatusForbidden {
+		t.Errorf("Expected deleting someone else's comment to be forbidden. Received %d", w.Code)
+	}
+	if w := serveAs(r, http.MethodPost, "/galleries/1/comments/9/delete", "", 2); w.Code != http.StatusFound || len(stubs.comments.deleted) != 1 {
+		t.Errorf("Expected the author to delete their comment. Received %d, %v", w.Code, stubs.comments.deleted)
+	}
+
+	w = serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/comments", "body=Nice", 3)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/galleries/1#image-5" {
+		t.Errorf("Expected a redirect to the image. Received %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if created := stubs.comments.created; len(created) != 1 || created[0].ImageID != 5 || created[0].UserID != 3 || created[0].Body != "Nice" {
+		t.Errorf("Expected the comment to be created. Received %v", stubs.comments.created)
+	}
+
+	stubs.galleries.gallery.CommentsDisabled = true
+	serveAs(r, http.MethodPost, "/galleries/1/images/"+validFilename+"/comments", "body=Nice", 3)
+	if len(stubs.comments.created) != 1 {
+		t.Errorf("Expected comments to be rejected once disabled. Received %v", stubs.comments.created)
+	}
+}
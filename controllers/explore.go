@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// exploreMaxAge is how long browsers and proxies may cache a page of
+// the explore listing.
+const exploreMaxAge = 60
+
+func NewExploreController(galleryService models.GalleryService, imageService models.ImageService, tagService models.TagService, userService models.UserService) *ExploreController {
+	return &ExploreController{
+		ExploreView:    views.NewView("bootstrap", "explore/index"),
+		galleryService: galleryService,
+		imgService:     imageService,
+		tagService:     tagService,
+		userService:    userService,
+	}
+}
+
+type ExploreController struct {
+	ExploreView    *views.View
+	galleryService models.GalleryService
+	imgService     models.ImageService
+	tagService     models.TagService
+	userService    models.UserService
+}
+
+// ExplorePage is a page of public galleries, filtered by Tag or Owner
+// when set.
+type ExplorePage struct {
+	Tag       *models.Tag
+	Owner     *models.User
+	Galleries []models.Gallery
+}
+
+type ExploreForm struct {
+	PageForm
+	Tag    string `schema:"tag"`
+	UserID uint   `schema:"user"`
+}
+
+// GET /explore
+//
+// Lists the public galleries of every user, newest first. Only public
+// galleries without a password are listed, so that pages are the same
+// for everyone and may be cached.
+func (exploreController *ExploreController) Index(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form ExploreForm
+	page := &ExplorePage{}
+	viewData.Yield = page
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+		exploreController.ExploreView.Render(w, r, viewData)
+		return
+	}
+
+	var filter models.PublicFilter
+	params := url.Values{}
+	if form.Tag != "" {
+		tag, err := exploreController.tagService.ByName(form.Tag)
+		if err != nil {
+			exploreController.filterNotFound(w, err)
+			return
+		}
+		page.Tag = tag
+		filter.TagID = tag.ID
+		params.Set("tag", tag.Name)
+	}
+	if form.UserID != 0 {
+		owner, err := exploreController.userService.ByID(form.UserID)
+		if err != nil {
+			exploreController.filterNotFound(w, err)
+			return
+		}
+		page.Owner = owner
+		filter.UserID = owner.ID
+		params.Set("user", strconv.Itoa(int(owner.ID)))
+	}
+
+	galleries, cursors, err := exploreController.galleryService.PagePublic(filter, form.query())
+	if err != nil {
+		viewData.SetAlert(err)
+		exploreController.ExploreView.Render(w, r, viewData)
+		return
+	}
+	if err := loadListing(exploreController.imgService, exploreController.tagService, galleries); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	page.Galleries = galleries
+
+	viewData.Pagination = newPagination("/explore", nil, cursors)
+	viewData.Pagination.Params = params
+	// only browsers may cache the page, as it carries the visitor's
+	// CSRF token and the CSRF cookie may be set along with it
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(exploreMaxAge))
+	w.Header().Set("Vary", "Cookie")
+	exploreController.ExploreView.Render(w, r, viewData)
+}
+
+// filterNotFound responds to a tag or user filter which doesn't exist.
+func (exploreController *ExploreController) filterNotFound(w http.ResponseWriter, err error) {
+	if err == models.ErrNotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	log.Println(err)
+	http.Error(w, "Something went wrong", http.StatusInternalServerError)
+}
+
+// loadListing fills in the cover, image count and tags of galleries.
+func loadListing(imgService models.ImageService, tagService models.TagService, galleries []models.Gallery) error {
+	galleryIDs := make([]uint, len(galleries))
+	for i := range galleries {
+		galleryIDs[i] = galleries[i].ID
+	}
+	stats, err := imgService.StatsByGalleryIDs(galleryIDs...)
+	if err != nil {
+		return err
+	}
+	covers, err := imgService.CoversByGalleries(galleries...)
+	if err != nil {
+		return err
+	}
+	tags, err := tagService.ByGalleryIDs(galleryIDs...)
+	if err != nil {
+		return err
+	}
+	for i := range galleries {
+		galleries[i].Stats = stats[galleries[i].ID]
+		galleries[i].Tags = tags[galleries[i].ID]
+		if cover, ok := covers[galleries[i].ID]; ok {
+			galleries[i].Cover = &cover
+		}
+	}
+	return nil
+}
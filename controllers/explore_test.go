@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type stubPublicGalleries struct {
+	models.GalleryService
+	filter models.PublicFilter
+	query  models.PageQuery
+}
+
+func (stub *stubPublicGalleries) PagePublic(filter models.PublicFilter, query models.PageQuery) ([]models.Gallery, *models.Page, error) {
+	stub.filter, stub.query = filter, query
+	gallery := models.Gallery{Title: "Beach day", Visibility: models.VisibilityPublic, UserID: 4, OwnerName: "Sam"}
+	gallery.ID = 1
+	return []models.Gallery{gallery}, &models.Page{Sort: models.SortCreated, Order: models.OrderDesc, Next: "next"}, nil
+}
+
+type stubUserService struct {
+	models.UserService
+	user models.User
+}
+
+func (stub *stubUserService) ByID(id uint) (*models.User, error) {
+	if id != stub.user.ID {
+		return nil, models.ErrNotFound
+	}
+	user := stub.user
+	return &user, nil
+}
+
+func TestExplore(t *testing.T) {
+	testingGalleryRouter(t)
+	galleries := &stubPublicGalleries{}
+	owner := models.User{Name: "Sam"}
+	owner.ID = 4
+	summer := models.Tag{Name: "summer"}
+	summer.ID = 3
+	exploreController := NewExploreController(galleries, &stubTaggedImages{},
+		&stubTagPageService{tag: summer}, &stubUserService{user: owner})
+
+	tests := []struct {
+		path     string
+		user     *models.User
+		expected int
+		cache    string
+	}{
+		{"/explore", nil, http.StatusOK, "private, max-age=60"},
+		{"/explore?tag=Summer&user=4&after=abc", nil, http.StatusOK, "private, max-age=60"},
+		{"/explore", &owner, http.StatusOK, "private, max-age=60"},
+		{"/explore?tag=winter", nil, http.StatusNotFound, ""},
+		{"/explore?user=5", nil, http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.user != nil {
+			req = req.WithContext(context.WithUser(req.Context(), test.user))
+		}
+		w := httptest.NewRecorder()
+		exploreController.Index(w, req)
+		if w.Code != test.expected {
+			t.Errorf("GET %s: expected %d. Received %d", test.path, test.expected, w.Code)
+			continue
+		}
+		if cache := w.Header().Get("Cache-Control"); w.Code == http.StatusOK && cache != test.cache {
+			t.Errorf("GET %s: expected Cache-Control %q. Received %q", test.path, test.cache, cache)
+		}
+	}
+
+	// filtered pages keep their filters in the link to the next page
+	req := httptest.NewRequest(http.MethodGet, "/explore?tag=Summer&user=4&after=abc", nil)
+	w := httptest.NewRecorder()
+	exploreController.Index(w, req)
+	expected := models.PublicFilter{TagID: 3, UserID: 4}
+	if galleries.filter != expected || galleries.query.After != "abc" {
+		t.Errorf("Expected a page after %q filtered by %+v. Received %+v, %+v", "abc", expected, galleries.filter, galleries.query)
+	}
+	body := w.Body.String()
+	for _, expected := range []string{
+		`href="/explore?after=next&amp;tag=summer&amp;user=4"`,
+		`Beach day`,
+		`href="/explore?user=4">Sam</a>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the page to contain %s", expected)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go-web-dev/models"
+	"hash/fnv"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// feedSize is the number of galleries in a feed
+	feedSize = 20
+	// feedImagesSize is the number of newest images included with
+	// each gallery
+	feedImagesSize = 5
+	// feedVariantSize is the size of the variants feeds point to
+	feedVariantSize = 800
+	// feedMaxAge is how long feed readers and proxies may cache a feed
+	feedMaxAge = 300
+)
+
+// NewFeedController creates a controller for the Atom and RSS feeds of
+// users' public galleries.
+func NewFeedController(userService models.UserService, galleryService models.GalleryService, imageService models.ImageService) *FeedController {
+	return &FeedController{
+		userService:    userService,
+		galleryService: galleryService,
+		imgService:     imageService,
+	}
+}
+
+type FeedController struct {
+	userService    models.UserService
+	galleryService models.GalleryService
+	imgService     models.ImageService
+}
+
+// userFeed is what both formats of a user's feed are made of: their
+// public galleries most recently updated first, each with its newest
+// images.
+type userFeed struct {
+	User      *models.User
+	Galleries []models.Gallery
+	Updated   time.Time
+	// BaseURL makes the links of the feed absolute
+	BaseURL string
+}
+
+// GET /users/:id/feed.atom
+func (feedController *FeedController) Atom(w http.ResponseWriter, r *http.Request) {
+	feed, err := feedController.fetchFeed(w, r)
+	if err != nil {
+		return
+	}
+	feedController.serveFeed(w, r, feed, "atom", newAtomFeed(feed))
+}
+
+// GET /users/:id/feed.rss
+func (feedController *FeedController) RSS(w http.ResponseWriter, r *http.Request) {
+	feed, err := feedController.fetchFeed(w, r)
+	if err != nil {
+		return
+	}
+	feedController.serveFeed(w, r, feed, "rss", newRSSFeed(feed))
+}
+
+// serveFeed writes the feed as XML in format, which is atom or rss.
+// Requests which already have the current version, according to its
+// ETag or when it was last updated, are answered with 304 Not Modified
+// instead.
+func (feedController *FeedController) serveFeed(w http.ResponseWriter, r *http.Request, feed *userFeed, format string, document interface{}) {
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	if err := xml.NewEncoder(&body).Encode(document); err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/"+format+"+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(feedMaxAge))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%x"`, format, feed.version()))
+	http.ServeContent(w, r, "", feed.Updated, bytes.NewReader(body.Bytes()))
+}
+
+// fetchFeed gathers the feed of the user in the request.
+func (feedController *FeedController) fetchFeed(w http.ResponseWriter, r *http.Request) (*userFeed, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := feedController.userService.ByID(uint(id))
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	feed, err := feedController.buildFeed(user)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return nil, err
+	}
+	feed.BaseURL = baseURL(r)
+	return feed, nil
+}
+
+// buildFeed loads the user's public galleries and their newest images.
+// Galleries are updated when any of their images are, and the feed
+// when any of its galleries are. Updates of the user themselves are
+// left out, as signing in or out updates them too.
+func (feedController *FeedController) buildFeed(user *models.User) (*userFeed, error) {
+	galleries, err := feedController.galleryService.ByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	feed := &userFeed{User: user, Updated: user.CreatedAt}
+	for _, gallery := range galleries {
+		if !gallery.Listed() {
+			continue
+		}
+		images, err := feedController.imgService.ByGalleryID(gallery.ID)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].CreatedAt.After(images[j].CreatedAt)
+		})
+		for _, img := range images {
+			if img.UpdatedAt.After(gallery.UpdatedAt) {
+				gallery.UpdatedAt = img.UpdatedAt
+			}
+		}
+		if len(images) > feedImagesSize {
+			images = images[:feedImagesSize]
+		}
+		gallery.Images = images
+		feed.Galleries = append(feed.Galleries, gallery)
+	}
+
+	sort.SliceStable(feed.Galleries, func(i, j int) bool {
+		return feed.Galleries[i].UpdatedAt.After(feed.Galleries[j].UpdatedAt)
+	})
+	if len(feed.Galleries) > feedSize {
+		feed.Galleries = feed.Galleries[:feedSize]
+	}
+	for _, gallery := range feed.Galleries {
+		if gallery.UpdatedAt.After(feed.Updated) {
+			feed.Updated = gallery.UpdatedAt
+		}
+	}
+	return feed, nil
+}
+
+// version changes whenever anything in the feed does, including when
+// galleries or images are deleted, which doesn't update anything.
+func (feed *userFeed) version() uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d %d %q", feed.User.ID, feed.Updated.UnixNano(), feed.User.Name)
+	for _, gallery := range feed.Galleries {
+		fmt.Fprintf(h, " %d:%d", gallery.ID, gallery.UpdatedAt.UnixNano())
+		for _, img := range gallery.Images {
+			fmt.Fprintf(h, ",%d", img.ID)
+		}
+	}
+	return h.Sum64()
+}
+
+func (feed *userFeed) url(path string) string {
+	return feed.BaseURL + path
+}
+
+func (feed *userFeed) profileURL() string {
+	return feed.url(userPath(feed.User.ID))
+}
+
+// content is the HTML shown for a gallery by feed readers: its
+// description followed by its newest images.
+func (feed *userFeed) content(gallery *models.Gallery) string {
+	var content strings.Builder
+	content.WriteString(string(gallery.DescriptionHTML()))
+	for i := range gallery.Images {
+		img := &gallery.Images[i]
+		fmt.Fprintf(&content, `<p><a href="%s#image-%d"><img src="%s" alt="%s"></a></p>`,
+			template.HTMLEscapeString(feed.url(gallery.Path())), img.ID,
+			template.HTMLEscapeString(feed.url(img.VariantRoute(feedVariantSize))),
+			template.HTMLEscapeString(img.OriginalName))
+	}
+	return content.String()
+}
+
+// feedImage is an image as feeds enclose it. Length is only known for
+// originals, as the size of variants isn't stored.
+type feedImage struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+func (feed *userFeed) image(img *models.Image) feedImage {
+	image := feedImage{URL: feed.url(img.VariantRoute(feedVariantSize)), Type: img.ContentType}
+	if !img.HasVariant(feedVariantSize) {
+		image.Length = img.Size
+	}
+	return image
+}
+
+// baseURL is the scheme and host the request was made to.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomPerson  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []atomLink `xml:"link"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func newAtomFeed(feed *userFeed) *atomFeed {
+	document := &atomFeed{
+		ID:      feed.profileURL(),
+		Title:   feed.User.Name + "'s galleries",
+		Updated: feed.Updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: feed.User.Name, URI: feed.profileURL()},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: feed.url(userPath(feed.User.ID) + "/feed.atom")},
+			{Rel: "alternate", Type: "text/html", Href: feed.profileURL()},
+		},
+	}
+	for i := range feed.Galleries {
+		gallery := &feed.Galleries[i]
+		entry := atomEntry{
+			ID:        feed.url(gallery.Path()),
+			Title:     gallery.Title,
+			Published: gallery.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   gallery.UpdatedAt.UTC().Format(time.RFC3339),
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: feed.url(gallery.Path())}},
+			Content:   atomText{Type: "html", Body: feed.content(gallery)},
+		}
+		for j := range gallery.Images {
+			image := feed.image(&gallery.Images[j])
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Type: image.Type, Href: image.URL, Length: image.Length})
+		}
+		document.Entries = append(document.Entries, entry)
+	}
+	return document
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	MediaNS string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Self          atomLink  `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+// rssItem may only have one enclosure, so every image is listed as
+// Media RSS content as well.
+type rssItem struct {
+	Title       string         `xml:"title"`
+	Link        string         `xml:"link"`
+	GUID        string         `xml:"guid"`
+	PubDate     string         `xml:"pubDate"`
+	Description string         `xml:"description"`
+	Enclosure   *rssEnclosure  `xml:"enclosure"`
+	Media       []mediaContent `xml:"media:content"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type mediaContent struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Medium   string `xml:"medium,attr"`
+	FileSize int64  `xml:"fileSize,attr,omitempty"`
+}
+
+func newRSSFeed(feed *userFeed) *rssFeed {
+	document := &rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		MediaNS: "http://search.yahoo.com/mrss/",
+		Channel: rssChannel{
+			Title:         feed.User.Name + "'s galleries",
+			Link:          feed.profileURL(),
+			Description:   "Public galleries by " + feed.User.Name,
+			LastBuildDate: feed.Updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: feed.url(userPath(feed.User.ID) + "/feed.rss")},
+		},
+	}
+	for i := range feed.Galleries {
+		gallery := &feed.Galleries[i]
+		item := rssItem{
+			Title:       gallery.Title,
+			Link:        feed.url(gallery.Path()),
+			GUID:        feed.url(gallery.Path()),
+			PubDate:     gallery.UpdatedAt.UTC().Format(time.RFC1123Z),
+			Description: feed.content(gallery),
+		}
+		for j := range gallery.Images {
+			image := feed.image(&gallery.Images[j])
+			if item.Enclosure == nil {
+				item.Enclosure = &rssEnclosure{URL: image.URL, Length: image.Length, Type: image.Type}
+			}
+			item.Media = append(item.Media, mediaContent{URL: image.URL, Type: image.Type, Medium: "image", FileSize: image.Length})
+		}
+		document.Channel.Items = append(document.Channel.Items, item)
+	}
+	return document
+}
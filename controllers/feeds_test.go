@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+type stubUserGalleries struct {
+	models.GalleryService
+	galleries []models.Gallery
+}
+
+func (stub *stubUserGalleries) ByUserID(userID uint) ([]models.Gallery, error) {
+	return stub.galleries, nil
+}
+
+func TestFeeds(t *testing.T) {
+	updated := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	owner := models.User{Name: "Sam"}
+	owner.ID = 4
+	owner.CreatedAt = updated.AddDate(0, -1, 0)
+	// signing in updates the user, which mustn't update their feed
+	owner.UpdatedAt = updated.Add(2 * time.Hour)
+	var galleries []models.Gallery
+	for i, visibility := range []string{models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityPublic} {
+		gallery := models.Gallery{Title: visibility, UserID: 4, Visibility: visibility}
+		gallery.ID = uint(i + 1)
+		gallery.UpdatedAt = updated.Add(-time.Duration(i) * time.Minute)
+		galleries = append(galleries, gallery)
+	}
+	galleries[0].Title = "Beach day"
+	galleries[2].PasswordHash = "hash"
+	img := models.Image{GalleryID: 1, Filename: validFilename, ContentType: "image/jpeg", Variants: pq.Int64Array{320, 800}}
+	img.ID = 7
+	img.UpdatedAt = updated.Add(time.Hour)
+
+	feedController := NewFeedController(&stubUserService{user: owner}, &stubUserGalleries{galleries: galleries},
+		&stubImageService{images: []models.Image{img}})
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id:[0-9]+}/feed.atom", feedController.Atom).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/feed.rss", feedController.RSS).Methods("GET")
+	w := serveAs(r, http.MethodGet, "/users/4/feed.atom", "", 0)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/atom+xml; charset=utf-8" {
+		t.Fatalf("Expected an Atom feed. Received %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	// only public galleries without a password are listed, updated
+	// along with their images
+	if len(atom.Entries) != 1 || atom.Entries[0].Title != "Beach day" {
+		t.Fatalf("Expected the public gallery only. Received %+v", atom.Entries)
+	}
+	if atom.Updated != "2021-06-01T13:00:00Z" || atom.Entries[0].Updated != atom.Updated {
+		t.Errorf("Expected the feed to be updated with the image. Received %q, %q", atom.Updated, atom.Entries[0].Updated)
+	}
+	enclosure := "http://example.com/images/galleries/1/variants/800/" + validFilename
+	if links := atom.Entries[0].Links; len(links) != 2 || links[1].Rel != "enclosure" || links[1].Href != enclosure {
+		t.Errorf("Expected an enclosure of the variant. Received %+v", links)
+	}
+
+	etag := w.Header().Get("ETag")
+	if etag == "" || w.Header().Get("Last-Modified") != "Tue, 01 Jun 2021 13:00:00 GMT" {
+		t.Errorf("Expected validators. Received %q, %q", etag, w.Header().Get("Last-Modified"))
+	}
+	conditionals := []struct {
+		header   string
+		value    string
+		expected int
+	}{
+		{"If-None-Match", etag, http.StatusNotModified},
+		{"If-Modified-Since", "Tue, 01 Jun 2021 13:00:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Tue, 01 Jun 2021 12:00:00 GMT", http.StatusOK},
+	}
+	for _, test := range conditionals {
+		req := testingRequest(http.MethodGet, "/users/4/feed.atom", "", 0)
+		req.Header.Set(test.header, test.value)
+		if w := serve(r, req); w.Code != test.expected {
+			t.Errorf("%s %s: expected %d. Received %d", test.header, test.value, test.expected, w.Code)
+		}
+	}
+
+	w = serveAs(r, http.MethodGet, "/users/4/feed.rss", "", 0)
+	body := w.Body.String()
+	for _, expected := range []string{
+		`<rss version="2.0"`,
+		`<enclosure url="` + enclosure + `" length="0" type="image/jpeg">`,
+		`<media:content url="` + enclosure + `" type="image/jpeg" medium="image">`,
+		`<pubDate>Tue, 01 Jun 2021 13:00:00 +0000</pubDate>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the RSS feed to contain %s. Received %s", expected, body)
+		}
+	}
+	if rssETag := w.Header().Get("ETag"); rssETag == etag {
+		t.Errorf("Expected the formats to have different ETags. Received %q", rssETag)
+	}
+
+	if w := serveAs(r, http.MethodGet, "/users/5/feed.rss", "", 0); w.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown users to be not found. Received %d", w.Code)
+	}
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// NewFollowController creates a controller for the public profiles of
+// users, following them and the feed of what they do.
+func NewFollowController(followService models.FollowService, userService models.UserService, galleryService models.GalleryService, imageService models.ImageService, tagService models.TagService) *FollowController {
+	return &FollowController{
+		ProfileView:    views.NewView("bootstrap", "users/profile"),
+		FeedView:       views.NewView("bootstrap", "feed/index"),
+		followService:  followService,
+		userService:    userService,
+		galleryService: galleryService,
+		imgService:     imageService,
+		tagService:     tagService,
+	}
+}
+
+type FollowController struct {
+	ProfileView    *views.View
+	FeedView       *views.View
+	followService  models.FollowService
+	userService    models.UserService
+	galleryService models.GalleryService
+	imgService     models.ImageService
+	tagService     models.TagService
+}
+
+// ProfilePage is a user's public profile: their public galleries and
+// who follows them.
+type ProfilePage struct {
+	Owner     *models.User
+	Counts    *models.FollowCounts
+	Following bool
+	// CanFollow is set for signed in users viewing someone else's
+	// profile
+	CanFollow bool
+	Galleries []models.Gallery
+}
+
+// Path is the URL of the profile.
+func (page *ProfilePage) Path() string {
+	return userPath(page.Owner.ID)
+}
+
+// GET /users/:id
+func (followController *FollowController) Profile(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form PageForm
+	owner, err := followController.fetchUser(w, r)
+	if err != nil {
+		return
+	}
+	page := &ProfilePage{Owner: owner, Counts: &models.FollowCounts{}}
+	viewData.Yield = page
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+		followController.ProfileView.Render(w, r, viewData)
+		return
+	}
+
+	galleries, cursors, err := followController.galleryService.PagePublic(models.PublicFilter{UserID: owner.ID}, form.query())
+	if err != nil {
+		viewData.SetAlert(err)
+		followController.ProfileView.Render(w, r, viewData)
+		return
+	}
+	if err := loadListing(followController.imgService, followController.tagService, galleries); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	page.Galleries = galleries
+
+	page.Counts, err = followController.followService.Counts(owner.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if viewer := context.User(r.Context()); viewer != nil && viewer.ID != owner.ID {
+		page.CanFollow = true
+		page.Following, err = followController.followService.IsFollowing(viewer.ID, owner.ID)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	viewData.Pagination = newPagination(page.Path(), nil, cursors)
+	followController.ProfileView.Render(w, r, viewData)
+}
+
+// POST /users/:id/follow
+func (followController *FollowController) Follow(w http.ResponseWriter, r *http.Request) {
+	owner, err := followController.fetchUser(w, r)
+	if err != nil {
+		return
+	}
+	err = followController.followService.Follow(context.User(r.Context()).ID, owner.ID)
+	followController.redirectToProfile(w, r, owner, err)
+}
+
+// POST /users/:id/unfollow
+func (followController *FollowController) Unfollow(w http.ResponseWriter, r *http.Request) {
+	owner, err := followController.fetchUser(w, r)
+	if err != nil {
+		return
+	}
+	err = followController.followService.Unfollow(context.User(r.Context()).ID, owner.ID)
+	followController.redirectToProfile(w, r, owner, err)
+}
+
+// GET /feed
+//
+// Lists what the users the current user follows did lately, newest
+// first.
+func (followController *FollowController) Feed(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form PageForm
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+		followController.FeedView.Render(w, r, viewData)
+		return
+	}
+	user := context.User(r.Context())
+	activities, cursors, err := followController.followService.Feed(user.ID, form.query())
+	if err != nil {
+		viewData.SetAlert(err)
+		followController.FeedView.Render(w, r, viewData)
+		return
+	}
+	viewData.Yield = activities
+	viewData.Pagination = newPagination("/feed", nil, cursors)
+	followController.FeedView.Render(w, r, viewData)
+}
+
+// fetchUser looks up the user whose profile the request is for.
+func (followController *FollowController) fetchUser(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, err
+	}
+	user, err := followController.userService.ByID(uint(id))
+	switch err {
+	case nil:
+		return user, nil
+	case models.ErrNotFound:
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+// redirectToProfile goes back to the user's profile, with err as the
+// alert if following them failed.
+func (followController *FollowController) redirectToProfile(w http.ResponseWriter, r *http.Request, user *models.User, err error) {
+	if err != nil {
+		redirectError(w, r, userPath(user.ID), err)
+		return
+	}
+	http.Redirect(w, r, userPath(user.ID), http.StatusFound)
+}
+
+func userPath(id uint) string {
+	return "/users/" + strconv.Itoa(int(id))
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// stubFollowService keeps follows as follower/followee pairs.
+type stubFollowService struct {
+	models.FollowService
+	follows    map[[2]uint]bool
+	activities []models.Activity
+}
+
+func (stub *stubFollowService) Follow(followerID, followeeID uint) error {
+	if followerID == followeeID {
+		return models.ErrFollowSelf
+	}
+	stub.follows[[2]uint{followerID, followeeID}] = true
+	return nil
+}
+
+func (stub *stubFollowService) Unfollow(followerID, followeeID uint) error {
+	delete(stub.follows, [2]uint{followerID, followeeID})
+	return nil
+}
+
+func (stub *stubFollowService) IsFollowing(followerID, followeeID uint) (bool, error) {
+	return stub.follows[[2]uint{followerID, followeeID}], nil
+}
+
+func (stub *stubFollowService) Counts(userID uint) (*models.FollowCounts, error) {
+	var counts models.FollowCounts
+	for pair := range stub.follows {
+		if pair[1] == userID {
+			counts.Followers++
+		}
+	}
+	return &counts, nil
+}
+
+func (stub *stubFollowService) Feed(userID uint, query models.PageQuery) ([]models.Activity, *models.Page, error) {
+	return stub.activities, &models.Page{Sort: models.SortCreated, Order: models.OrderDesc}, nil
+}
+
+func TestFollows(t *testing.T) {
+	testingGalleryRouter(t)
+	owner := models.User{Name: "Sam"}
+	owner.ID = 4
+	follows := &stubFollowService{follows: make(map[[2]uint]bool)}
+	galleries := &stubPublicGalleries{}
+	followController := NewFollowController(follows, &stubUserService{user: owner}, galleries,
+		&stubTaggedImages{}, &stubTagPageService{})
+	r := mux.NewRouter()
+	r.HandleFunc("/users/{id:[0-9]+}", followController.Profile).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/follow", followController.Follow).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}/unfollow", followController.Unfollow).Methods("POST")
+	r.HandleFunc("/feed", followController.Feed).Methods("GET")
+
+	w := serveAs(r, http.MethodGet, "/users/4", "", 0)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, "Beach day") || strings.Contains(body, "/users/4/follow") {
+		t.Errorf("Expected the public galleries without a follow button. Received %d %s", w.Code, body)
+	}
+	if galleries.filter.UserID != 4 {
+		t.Errorf("Expected the galleries of user 4. Received %+v", galleries.filter)
+	}
+	if w := serveAs(r, http.MethodGet, "/users/5", "", 0); w.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown users to be not found. Received %d", w.Code)
+	}
+
+	if w := serveAs(r, http.MethodPost, "/users/4/follow", "", 2); w.Code != http.StatusFound || !follows.follows[[2]uint{2, 4}] {
+		t.Errorf("Expected user 2 to follow user 4. Received %d", w.Code)
+	}
+	if body := serveAs(r, http.MethodGet, "/users/4", "", 2).Body.String(); !strings.Contains(body, "/users/4/unfollow") || !strings.Contains(body, "1 followers") {
+		t.Errorf("Expected an unfollow button and a follower. Received %s", body)
+	}
+	if body := serveAs(r, http.MethodGet, "/users/4", "", 4).Body.String(); strings.Contains(body, "/users/4/follow") {
+		t.Errorf("Expected no follow button on your own profile. Received %s", body)
+	}
+	serveAs(r, http.MethodPost, "/users/4/follow", "", 4)
+	if follows.follows[[2]uint{4, 4}] {
+		t.Errorf("Expected following yourself to be rejected")
+	}
+	if serveAs(r, http.MethodPost, "/users/4/unfollow", "", 2); follows.follows[[2]uint{2, 4}] {
+		t.Errorf("Expected user 2 to unfollow user 4")
+	}
+
+	gallery := models.Gallery{Title: "Beach day"}
+	gallery.ID = 1
+	img := models.Image{GalleryID: 1, Filename: validFilename}
+	img.ID = 7
+	follows.activities = []models.Activity{{
+		Kind:       models.ActivityUpload,
+		GalleryID:  1,
+		UserID:     4,
+		At:         time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+		ImageCount: 3,
+		Gallery:    gallery,
+		User:       owner,
+		Images:     []models.Image{img},
+	}}
+	body := serveAs(r, http.MethodGet, "/feed", "", 2).Body.String()
+	for _, expected := range []string{
+		`<a href="/users/4"><strong>Sam</strong></a>`,
+		`added 3 photos to`,
+		`href="/galleries/1#image-7"`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the feed to contain %s. Received %s", expected, body)
+		}
+	}
+}
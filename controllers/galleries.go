@@ -1,12 +1,21 @@
 package controllers
 
 import (
+	"archive/zip"
+	"fmt"
 	"go-web-dev/context"
+	"go-web-dev/email"
 	"go-web-dev/models"
 	"go-web-dev/views"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/gorilla/mux"
 )
@@ -18,30 +27,76 @@ const (
 	maxMultipartMemory = 100 << 20 // 100 megabytes
 )
 
-func NewGalleryController(galleryService models.GalleryService, imageService models.ImageService, r *mux.Router) *GalleryController {
+// NewGalleryController creates a controller for galleries and their
+// images, working with the gallery, image, share link, member, tag,
+// comment, collection and analytics services of services.
+// maxUploadBytes limits the size of a single upload request.
+func NewGalleryController(services *models.Services, emailClient *email.Client, r *mux.Router, maxUploadBytes int64) *GalleryController {
 	return &GalleryController{
-		NewView:        views.NewView("bootstrap", "galleries/new"),
-		IndexView:      views.NewView("bootstrap", "galleries/index"),
-		ShowView:       views.NewView("bootstrap", "galleries/show"),
-		EditView:       views.NewView("bootstrap", "galleries/edit"),
-		galleryService: galleryService,
-		imgService:     imageService,
-		router:         r,
+		NewView:           views.NewView("bootstrap", "galleries/new"),
+		IndexView:         views.NewView("bootstrap", "galleries/index"),
+		ShowView:          views.NewView("bootstrap", "galleries/show"),
+		EditView:          views.NewView("bootstrap", "galleries/edit"),
+		PasswordView:      views.NewView("bootstrap", "galleries/password"),
+		InvitationView:    views.NewView("bootstrap", "galleries/invitation"),
+		AnalyticsView:     views.NewView("bootstrap", "galleries/analytics"),
+		galleryService:    services.Gallery,
+		imgService:        services.Image,
+		shareLinkService:  services.ShareLink,
+		memberService:     services.Member,
+		tagService:        services.Tag,
+		commentService:    services.Comment,
+		collectionService: services.Collection,
+		analyticsService:  services.Analytics,
+		emailClient:       emailClient,
+		router:            r,
+		maxUploadBytes:    maxUploadBytes,
 	}
 }
 
 type GalleryController struct {
-	NewView        *views.View
-	IndexView      *views.View
-	ShowView       *views.View
-	EditView       *views.View
-	galleryService models.GalleryService
-	imgService     models.ImageService
-	router         *mux.Router
+	NewView           *views.View
+	IndexView         *views.View
+	ShowView          *views.View
+	EditView          *views.View
+	PasswordView      *views.View
+	InvitationView    *views.View
+	AnalyticsView     *views.View
+	galleryService    models.GalleryService
+	imgService        models.ImageService
+	shareLinkService  models.ShareLinkService
+	memberService     models.MemberService
+	tagService        models.TagService
+	commentService    models.CommentService
+	collectionService models.CollectionService
+	analyticsService  models.AnalyticsService
+	emailClient       *email.Client
+	router            *mux.Router
+	maxUploadBytes    int64
 }
 
 type GalleryForm struct {
-	Title string `schema:"title"`
+	Title        string `schema:"title"`
+	Description  string `schema:"description"`
+	Tags         string `schema:"tags"`
+	Visibility   string `schema:"visibility"`
+	KeepLocation bool   `schema:"keep_location"`
+	ImageSort    string `schema:"image_sort"`
+	// CommentsDisabled stops new comments on the gallery's images
+	CommentsDisabled bool `schema:"comments_disabled"`
+	// Password replaces the gallery's password unless left empty
+	Password       string `schema:"password"`
+	RemovePassword bool   `schema:"remove_password"`
+}
+
+// ImageOrderForm lists the filenames of every image in a gallery in
+// the order they should be shown.
+type ImageOrderForm struct {
+	Filenames []string `schema:"filenames"`
+}
+
+type ImageCaptionForm struct {
+	Caption string `schema:"caption"`
 }
 
 func (galleryController *GalleryController) Create(w http.ResponseWriter, r *http.Request) {
@@ -57,8 +112,9 @@ func (galleryController *GalleryController) Create(w http.ResponseWriter, r *htt
 	user := context.User(r.Context())
 
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:      form.Title,
+		Visibility: form.Visibility,
+		UserID:     user.ID,
 	}
 	if err := galleryController.galleryService.Create(&gallery); err != nil {
 		viewData.SetAlert(err)
@@ -76,51 +132,258 @@ func (galleryController *GalleryController) Create(w http.ResponseWriter, r *htt
 }
 
 // GET /galleries
+//
+// Lists a page of the galleries the user owns or is a member of.
 func (galleryController *GalleryController) Index(w http.ResponseWriter, r *http.Request) {
 	var viewData views.Data
+	var form PageForm
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.IndexView.Render(w, r, viewData)
+		return
+	}
 
 	user := context.User(r.Context())
-	galleries, err := galleryController.galleryService.ByUserID(user.ID)
+	galleries, page, err := galleryController.galleryService.PageForUser(user.ID, form.query())
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.IndexView.Render(w, r, viewData)
+		return
+	}
+	if err := galleryController.loadRoles(user, galleries); err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	galleryIDs := make([]uint, len(galleries))
+	for i := range galleries {
+		galleryIDs[i] = galleries[i].ID
+	}
+	stats, err := galleryController.imgService.StatsByGalleryIDs(galleryIDs...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	covers, err := galleryController.imgService.CoversByGalleries(galleries...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	tags, err := galleryController.tagService.ByGalleryIDs(galleryIDs...)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	for i := range galleries {
+		galleries[i].Stats = stats[galleries[i].ID]
+		galleries[i].Tags = tags[galleries[i].ID]
+		if cover, ok := covers[galleries[i].ID]; ok {
+			galleries[i].Cover = &cover
+		}
+	}
 
+	viewData.Pagination = newPagination("/galleries", models.GallerySorts, page)
 	viewData.Yield = galleries
 	galleryController.IndexView.Render(w, r, viewData)
 }
 
+// loadRoles fills in the user's role in each of the galleries, which
+// they either own or are a member of.
+func (galleryController *GalleryController) loadRoles(user *models.User, galleries []models.Gallery) error {
+	members, err := galleryController.memberService.ByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	roles := make(map[uint]string, len(members))
+	for _, member := range members {
+		roles[member.GalleryID] = member.Role
+	}
+	for i := range galleries {
+		if galleries[i].UserID == user.ID {
+			galleries[i].Role = models.RoleOwner
+		} else {
+			galleries[i].Role = roles[galleries[i].ID]
+		}
+	}
+	return nil
+}
+
 // GET /galleries/:id
+// GET /g/:public_id
 func (galleryController *GalleryController) Show(w http.ResponseWriter, r *http.Request) {
 	var viewData views.Data
 
-	gallery, err := galleryController.fetchGallery(w, r)
+	gallery, err := galleryController.fetchVisibleGallery(w, r)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+	galleryController.loadTags(gallery)
+	galleryController.loadComments(gallery)
+	galleryController.recordEvent(r, gallery, models.EventView, 0)
 
-	viewData.Yield = gallery
+	page := &GalleryPage{Gallery: gallery, Viewer: context.User(r.Context())}
+	galleryController.loadCollections(page)
+	viewData.Yield = page
 	galleryController.ShowView.Render(w, r, viewData)
 }
 
+// GET /galleries/:id/download
+// GET /g/:public_id/download
+//
+// Streams a ZIP archive of the gallery's originals. Passing one or more
+// files parameters limits the archive to those filenames.
+func (galleryController *GalleryController) Download(w http.ResponseWriter, r *http.Request) {
+	gallery, err := galleryController.fetchVisibleGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+
+	galleryController.writeArchive(w, r, gallery)
+}
+
+// writeArchive streams the gallery's images, or those selected by the
+// files parameters, as a ZIP archive.
+func (galleryController *GalleryController) writeArchive(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) {
+	images := gallery.Images
+	if filenames := r.URL.Query()["files"]; len(filenames) > 0 {
+		var err error
+		images, err = selectImages(gallery.Images, filenames)
+		if err != nil {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+	}
+	galleryController.recordEvent(r, gallery, models.EventDownload, 0)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": archiveName(gallery.Title, "gallery") + ".zip",
+	}))
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool, len(images))
+	for i := range images {
+		// the response has already started, so all we can do on
+		// failure is cut the archive short
+		if err := galleryController.writeArchiveEntry(archive, &images[i], used); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (galleryController *GalleryController) writeArchiveEntry(archive *zip.Writer, img *models.Image, used map[string]bool) error {
+	file, err := galleryController.imgService.Open(img, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// photos are already compressed, so they are stored as is
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     uniqueArchiveName(img, used),
+		Method:   zip.Store,
+		Modified: img.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, file)
+	return err
+}
+
+// selectImages returns the images with the given filenames in gallery
+// order, failing if any of them is not part of the gallery.
+func selectImages(images []models.Image, filenames []string) ([]models.Image, error) {
+	wanted := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		if !models.ValidFilename(filename) {
+			return nil, models.ErrNotFound
+		}
+		wanted[filename] = true
+	}
+	var selected []models.Image
+	for _, img := range images {
+		if wanted[img.Filename] {
+			selected = append(selected, img)
+		}
+	}
+	if len(selected) != len(wanted) {
+		return nil, models.ErrNotFound
+	}
+	return selected, nil
+}
+
+// uniqueArchiveName names an image after the file it was uploaded as,
+// numbering repeated names so that no entry overwrites another.
+func uniqueArchiveName(img *models.Image, used map[string]bool) string {
+	ext := path.Ext(img.Filename)
+	name := strings.TrimSuffix(archiveName(img.OriginalName, ""), path.Ext(img.OriginalName))
+	if name == "" {
+		name = strings.TrimSuffix(img.Filename, ext)
+	}
+	candidate := name + ext
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s (%d)%s", name, i, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// archiveName reduces a user supplied name to a single path segment
+// which is safe to use within an archive or as a download filename.
+func archiveName(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		return fallback
+	}
+	return name
+}
+
 // GET /galleries/:id/edit
 func (galleryController *GalleryController) Edit(w http.ResponseWriter, r *http.Request) {
 	var viewData views.Data
 
-	gallery, err := galleryController.fetchGallery(w, r)
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionUpload)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
+	galleryController.renderEdit(w, r, viewData, gallery)
+}
 
-	viewData.User = context.User(r.Context())
-	if gallery.UserID != viewData.User.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
+// renderEdit renders the edit page of the gallery with everything Edit
+// shows on it. Tags typed into a form which failed are shown as typed
+// rather than as they are stored.
+func (galleryController *GalleryController) renderEdit(w http.ResponseWriter, r *http.Request, viewData views.Data, gallery *models.Gallery) {
+	typed := gallery.Tags
+	galleryController.loadTags(gallery)
+	if typed != nil {
+		gallery.Tags = typed
+	}
+	if gallery.CanManage() {
+		galleryController.loadShareLinks(gallery)
+		galleryController.loadMembers(gallery)
 	}
-
 	viewData.Yield = gallery
 	galleryController.EditView.Render(w, r, viewData)
 }
@@ -130,36 +393,59 @@ func (galleryController *GalleryController) Update(w http.ResponseWriter, r *htt
 	var viewData views.Data
 	var form GalleryForm
 
-	gallery, err := galleryController.fetchGallery(w, r)
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
 
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
-
 	if err := parseForm(r, &form); err != nil {
 		viewData.SetAlert(err)
-		galleryController.EditView.Render(w, r, viewData)
+		galleryController.renderEdit(w, r, viewData, gallery)
 		return
 	}
 
+	gallery.Tags = typedTags(form.Tags)
 	gallery.Title = form.Title
+	gallery.Description = form.Description
+	gallery.ImageSort = form.ImageSort
+	// who can see the gallery is left to its owner
+	if gallery.CanManage() {
+		gallery.Visibility = form.Visibility
+		gallery.KeepLocation = form.KeepLocation
+		gallery.CommentsDisabled = form.CommentsDisabled
+		if form.RemovePassword {
+			gallery.PasswordHash = ""
+		} else {
+			gallery.Password = form.Password
+		}
+	}
+	// the tags are checked first, so that invalid ones leave the
+	// gallery unchanged
+	tags, err := models.NormalizeTags(models.ParseTags(form.Tags))
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
 	if err := galleryController.galleryService.Update(gallery); err != nil {
 		viewData.SetAlert(err)
-		galleryController.EditView.Render(w, r, viewData)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	saved, err := galleryController.tagService.SetGalleryTags(gallery.ID, tags)
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
 		return
 	}
+	gallery.Tags = saved
 
 	viewData.Alert = &views.Alert{
 		Level:   views.AlertLevelSuccess,
 		Message: "Gallery succesfully updated!",
 	}
-	galleryController.EditView.Render(w, r, viewData)
+	galleryController.renderEdit(w, r, viewData, gallery)
 }
 
 // POST /galleries/:id/images
@@ -167,42 +453,60 @@ func (galleryController *GalleryController) UploadImage(w http.ResponseWriter, r
 	var viewData views.Data
 
 	// get gallery corresponding to path
-	gallery, err := galleryController.fetchGallery(w, r)
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionUpload)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
 
-	// ensure user has access to gallery (owns it)
 	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
 
 	// parse multipart form with images
+	if galleryController.maxUploadBytes > 0 {
+		if r.ContentLength > galleryController.maxUploadBytes {
+			viewData.AlertError(fmt.Sprintf("Uploads are limited to %d MB at a time", galleryController.maxUploadBytes>>20))
+			galleryController.renderEdit(w, r, viewData, gallery)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, galleryController.maxUploadBytes)
+	}
 	err = r.ParseMultipartForm(maxMultipartMemory)
 	if err != nil {
 		viewData.SetAlert(err)
-		galleryController.EditView.Render(w, r, viewData)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
 	}
 
+	var summary models.ArchiveSummary
 	files := r.MultipartForm.File["images"]
 	for _, fileHeader := range files {
-		srcFile, err := fileHeader.Open()
-		if err != nil {
-			viewData.SetAlert(err)
-			galleryController.EditView.Render(w, r, viewData)
-			return
+		img := models.Image{
+			GalleryID:    gallery.ID,
+			UserID:       user.ID,
+			OriginalName: fileHeader.Filename,
 		}
-		defer srcFile.Close()
-
-		err = galleryController.imgService.Create(gallery.ID, srcFile, fileHeader.Filename)
-		if err != nil {
-			viewData.SetAlert(err)
-			galleryController.EditView.Render(w, r, viewData)
-			return
+		if err := galleryController.createImage(gallery, &img, fileHeader); err != nil {
+			summary.Rejected = append(summary.Rejected, models.FileError{Filename: fileHeader.Filename, Err: err})
+			continue
+		}
+		summary.Uploaded = append(summary.Uploaded, fileHeader.Filename)
+	}
+	archives := r.MultipartForm.File["archive"]
+	for _, fileHeader := range archives {
+		if err := galleryController.extractArchive(gallery, user.ID, fileHeader, &summary); err != nil {
+			summary.Rejected = append(summary.Rejected, models.FileError{Filename: fileHeader.Filename, Err: err})
+		}
+	}
+	if len(archives) > 0 || len(summary.Rejected) > 0 {
+		gallery.Images, _ = galleryController.imgService.ByGalleryID(gallery.ID)
+		gallery.SortImages()
+		if len(archives) > 0 {
+			viewData.Alert = uploadSummaryAlert(&summary)
+		} else {
+			viewData.SetAlert(summary.Rejected)
 		}
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
 	}
 
 	url, err := galleryController.router.Get(EditGalleryRoute).URL("id", strconv.Itoa(int(gallery.ID)))
@@ -214,30 +518,175 @@ func (galleryController *GalleryController) UploadImage(w http.ResponseWriter, r
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
+func (galleryController *GalleryController) createImage(gallery *models.Gallery, img *models.Image, fileHeader *multipart.FileHeader) error {
+	srcFile, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	return galleryController.imgService.Create(gallery, img, srcFile)
+}
+
+// extractArchive adds the images of an uploaded ZIP archive to the
+// gallery, recording the outcome of each file in summary.
+func (galleryController *GalleryController) extractArchive(gallery *models.Gallery, userID uint, fileHeader *multipart.FileHeader, summary *models.ArchiveSummary) error {
+	srcFile, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	extracted, err := galleryController.imgService.CreateFromArchive(gallery, userID, srcFile, fileHeader.Size)
+	if err != nil {
+		return err
+	}
+	summary.Uploaded = append(summary.Uploaded, extracted.Uploaded...)
+	summary.Skipped = append(summary.Skipped, extracted.Skipped...)
+	summary.Rejected = append(summary.Rejected, extracted.Rejected...)
+	return nil
+}
+
+// uploadSummaryAlert reports the outcome of every file of an upload.
+func uploadSummaryAlert(summary *models.ArchiveSummary) *views.Alert {
+	alert := views.Alert{
+		Level: views.AlertLevelSuccess,
+		Message: fmt.Sprintf("Uploaded %d of %d photos",
+			len(summary.Uploaded), len(summary.Uploaded)+len(summary.Rejected)),
+	}
+	if len(summary.Rejected) > 0 {
+		alert.Level = views.AlertLevelWarning
+	}
+	if len(summary.Skipped) > 0 {
+		alert.Message += fmt.Sprintf(", skipped %d files which are not images", len(summary.Skipped))
+	}
+	alert.Details = summary.Rejected.Details()
+	for _, filename := range summary.Skipped {
+		alert.Details = append(alert.Details, filename+": Skipped, not a JPEG or PNG image")
+	}
+	for _, filename := range summary.Uploaded {
+		alert.Details = append(alert.Details, filename+": Uploaded")
+	}
+	return &alert
+}
+
 // POST /galleries/:id/images/:filename/delete
 func (galleryController *GalleryController) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	var viewData views.Data
-	gallery, err := galleryController.fetchGallery(w, r)
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
 
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	filename := mux.Vars(r)["filename"]
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	err = galleryController.imgService.Delete(img)
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	if gallery.CoverImageID == img.ID {
+		gallery.CoverImageID = 0
+		if err := galleryController.galleryService.Update(gallery); err != nil {
+			log.Println(err)
+		}
+	}
+
+	url, err := galleryController.router.Get(EditGalleryRoute).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// POST /galleries/:id/images/order
+//
+// Requests made with XMLHttpRequest, e.g. by the drag and drop
+// editor, receive an empty response instead of a redirect.
+func (galleryController *GalleryController) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form ImageOrderForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
+	viewData.Yield = gallery
+	if err != nil {
+		return
+	}
+
+	xhr := r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+	err = parseForm(r, &form)
+	if err == nil {
+		err = galleryController.imgService.Reorder(gallery.ID, form.Filenames)
+	}
+	if err == nil {
+		gallery.ImageSort = models.ImageSortManual
+		err = galleryController.galleryService.Update(gallery)
+	}
+	if err != nil {
+		viewData.SetAlert(err)
+		if xhr {
+			http.Error(w, viewData.Alert.Message, http.StatusBadRequest)
+			return
+		}
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	if xhr {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	url, err := galleryController.router.Get(EditGalleryRoute).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, url.Path, http.StatusFound)
+}
+
+// POST /galleries/:id/images/:filename/cover
+func (galleryController *GalleryController) SetCover(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
+	viewData.Yield = gallery
+	if err != nil {
 		return
 	}
 
 	filename := mux.Vars(r)["filename"]
-	img := models.Image{
-		Filename:  filename,
-		GalleryID: gallery.ID,
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
 	}
-	err = galleryController.imgService.Delete(&img)
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
 	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
 		viewData.SetAlert(err)
-		galleryController.EditView.Render(w, r, viewData)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	gallery.CoverImageID = img.ID
+	if err := galleryController.galleryService.Update(gallery); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
 		return
 	}
 
@@ -250,48 +699,271 @@ func (galleryController *GalleryController) DeleteImage(w http.ResponseWriter, r
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
-// POST /galleries/:id/delete
-func (galleryController *GalleryController) Delete(w http.ResponseWriter, r *http.Request) {
+// POST /galleries/:id/images/:filename/caption
+func (galleryController *GalleryController) CaptionImage(w http.ResponseWriter, r *http.Request) {
 	var viewData views.Data
-	gallery, err := galleryController.fetchGallery(w, r)
+	var form ImageCaptionForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
 	viewData.Yield = gallery
 	if err != nil {
 		return
 	}
 
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
+	filename := mux.Vars(r)["filename"]
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	img.Caption = form.Caption
+	if err := galleryController.imgService.Update(img); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Caption of " + img.OriginalName + " saved.",
+	})
+}
+
+// POST /galleries/:id/delete
+func (galleryController *GalleryController) Delete(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	viewData.Yield = gallery
+	if err != nil {
 		return
 	}
 	if err := galleryController.galleryService.Delete(gallery.ID); err != nil {
 		viewData.SetAlert(err)
-		viewData.Yield = gallery
-		galleryController.EditView.Render(w, r, viewData)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: gallery.Title + " was moved to the trash.",
+	})
+}
+
+// GET /images/galleries/:id/:filename
+// GET /images/galleries/:id/variants/:size/:filename
+func (galleryController *GalleryController) ServeImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	galleryID, err := strconv.Atoi(vars["id"])
+	if err != nil || !models.ValidFilename(vars["filename"]) {
+		http.NotFound(w, r)
+		return
+	}
+	// images of deleted galleries are no longer served, even while
+	// their files are waiting to be reconciled. Filenames are as hard
+	// to guess as public IDs, so images of unlisted galleries are
+	// served to anyone who has their link.
+	gallery, err := galleryController.galleryService.ByID(uint(galleryID))
+	if err != nil {
+		if err != models.ErrNotFound {
+			log.Println(err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	if err := galleryController.loadRole(w, r, gallery); err != nil {
+		return
+	}
+	if !gallery.VisibleTo(context.User(r.Context()), true) && !galleryController.sharedWith(r, gallery) {
+		http.NotFound(w, r)
+		return
+	}
+	if !galleryController.unlocked(r, gallery) {
+		http.NotFound(w, r)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(uint(galleryID), vars["filename"])
+	if err != nil {
+		if err != models.ErrNotFound {
+			log.Println(err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	size := 0
+	if sizeStr, ok := vars["size"]; ok {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil || !img.HasVariant(size) {
+			http.NotFound(w, r)
+			return
+		}
 	}
-	http.Redirect(w, r, "/galleries", http.StatusFound)
+	// only opening an image through its ViewRoute counts as viewing
+	// it, not loading it on the gallery's page
+	if size == 0 && r.URL.Query().Get(models.ImageViewParam) != "" {
+		galleryController.recordEvent(r, gallery, models.EventImageView, img.ID)
+	}
+
+	if url := galleryController.imgService.URL(img, size); url != "" {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s-%d"`, img.Checksum, size)
+	w.Header().Set("ETag", etag)
+	if gallery.Visibility == models.VisibilityPrivate {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	file, err := galleryController.imgService.Open(img, size)
+	if err != nil {
+		if err != models.ErrNotFound {
+			log.Println(err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, file)
 }
 
+// fetchGallery looks up the gallery of the request, by its public ID
+// when the route has one and by its numeric ID otherwise, along with
+// its images and the current user's role. Nothing is checked about who
+// may access it.
 func (galleryController *GalleryController) fetchGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
-	idStr := mux.Vars(r)["id"]
-	id, err := strconv.Atoi(idStr)
+	var gallery *models.Gallery
+	vars := mux.Vars(r)
+	if publicID, ok := vars["public_id"]; ok {
+		var err error
+		gallery, err = galleryController.galleryService.ByPublicID(publicID)
+		if err != nil {
+			return nil, galleryLookupError(w, err)
+		}
+	} else {
+		id, err := strconv.Atoi(vars["id"])
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+			return nil, err
+		}
+		gallery, err = galleryController.galleryService.ByID(uint(id))
+		if err != nil {
+			return nil, galleryLookupError(w, err)
+		}
+	}
+
+	if err := galleryController.loadRole(w, r, gallery); err != nil {
+		return nil, err
+	}
+	if err := galleryController.loadImages(w, gallery); err != nil {
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// loadImages fills in the images of the gallery in display order.
+func (galleryController *GalleryController) loadImages(w http.ResponseWriter, gallery *models.Gallery) error {
+	var err error
+	gallery.Images, err = galleryController.imgService.ByGalleryID(gallery.ID)
 	if err != nil {
 		log.Println(err)
-		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return err
+	}
+	gallery.SortImages()
+	gallery.Stats = models.ImageStats{GalleryID: gallery.ID, Count: len(gallery.Images)}
+	for _, img := range gallery.Images {
+		gallery.Stats.Bytes += img.Size
+	}
+	return nil
+}
+
+// fetchVisibleGallery is fetchGallery for pages which visitors may
+// see. Galleries the current user may not view are reported as not
+// found so that their existence isn't revealed.
+func (galleryController *GalleryController) fetchVisibleGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	gallery, err := galleryController.fetchGallery(w, r)
+	if err != nil {
 		return nil, err
 	}
-	gallery, err := galleryController.galleryService.ByID(uint(id))
-	gallery.Images, _ = galleryController.imgService.ByGalleryID(gallery.ID)
+	_, byPublicID := mux.Vars(r)["public_id"]
+	if !gallery.VisibleTo(context.User(r.Context()), byPublicID) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, models.ErrNotFound
+	}
+	return gallery, nil
+}
+
+// fetchAuthorizedGallery is fetchGallery for actions which the current
+// user's role in the gallery has to allow. Galleries the user can't
+// view are reported as not found so that their existence isn't
+// revealed.
+func (galleryController *GalleryController) fetchAuthorizedGallery(w http.ResponseWriter, r *http.Request, permission models.Permission) (*models.Gallery, error) {
+	gallery, err := galleryController.fetchGallery(w, r)
 	if err != nil {
-		switch err {
-		case models.ErrNotFound:
+		return nil, err
+	}
+	if !gallery.Can(permission) {
+		if gallery.Can(models.PermissionView) {
+			http.Error(w, "You don't have permission to do that", http.StatusForbidden)
+		} else {
 			http.Error(w, "Gallery not found", http.StatusNotFound)
-			return nil, err
-		default:
+		}
+		return nil, errForbidden
+	}
+	return gallery, nil
+}
+
+// loadRole fills in the current user's role in the gallery.
+func (galleryController *GalleryController) loadRole(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
+	user := context.User(r.Context())
+	switch {
+	case user == nil:
+		gallery.Role = ""
+	case gallery.OwnedBy(user):
+		gallery.Role = models.RoleOwner
+	default:
+		role, err := galleryController.memberService.Role(gallery.ID, user.ID)
+		if err != nil {
 			log.Println(err)
 			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
-			return nil, err
+			return err
 		}
+		gallery.Role = role
 	}
-	return gallery, nil
+	return nil
+}
+
+// galleryLookupError responds to a failed gallery lookup and returns
+// the error for the caller to pass on.
+func galleryLookupError(w http.ResponseWriter, err error) error {
+	switch err {
+	case models.ErrNotFound:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+	}
+	return err
 }
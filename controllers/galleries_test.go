@@ -0,0 +1,753 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const validFilename = "0123456789abcdef0123456789abcdef.jpg"
+
+type stubGalleryService struct {
+	models.GalleryService
+	gallery models.Gallery
+	updated []models.Gallery
+	query   models.PageQuery
+}
+
+func (stub *stubGalleryService) ByPublicID(publicID string) (*models.Gallery, error) {
+	if publicID != stub.gallery.PublicID {
+		return nil, models.ErrNotFound
+	}
+	gallery := stub.gallery
+	return &gallery, nil
+}
+
+func (stub *stubGalleryService) Update(gallery *models.Gallery) error {
+	if gallery.Title == "" {
+		return models.ErrRequiredTitle
+	}
+	stub.updated = append(stub.updated, *gallery)
+	return nil
+}
+
+func (stub *stubGalleryService) ByID(id uint) (*models.Gallery, error) {
+	if id != stub.gallery.ID {
+		return nil, models.ErrNotFound
+	}
+	gallery := stub.gallery
+	return &gallery, nil
+}
+
+// PageForUser returns the gallery on a page between two others.
+func (stub *stubGalleryService) PageForUser(userID uint, query models.PageQuery) ([]models.Gallery, *models.Page, error) {
+	stub.query = query
+	page := &models.Page{Sort: models.SortTitle, Order: models.OrderAsc, Next: "next", Prev: "prev"}
+	return []models.Gallery{stub.gallery}, page, nil
+}
+
+// Unlock accepts "secret" as the password of every gallery.
+func (stub *stubGalleryService) Unlock(gallery *models.Gallery, password string) error {
+	if password != "secret" {
+		return models.ErrIncorrectPassword
+	}
+	return nil
+}
+
+func (stub *stubGalleryService) AccessToken(gallery *models.Gallery, expiresAt time.Time) string {
+	return "access"
+}
+
+func (stub *stubGalleryService) ValidAccessToken(gallery *models.Gallery, token string) bool {
+	return token == "access"
+}
+
+type stubImageService struct {
+	models.ImageService
+	requested []string
+	deleted   []string
+	order     []string
+	images    []models.Image
+}
+
+func (stub *stubImageService) Reorder(galleryID uint, filenames []string) error {
+	if len(filenames) == 0 {
+		return models.ErrInvalidImageOrder
+	}
+	stub.order = filenames
+	return nil
+}
+
+func (stub *stubImageService) ByFilename(galleryID uint, filename string) (*models.Image, error) {
+	stub.requested = append(stub.requested, filename)
+	if filename != validFilename {
+		return nil, models.ErrNotFound
+	}
+	for _, img := range stub.images {
+		if img.Filename == filename {
+			return &img, nil
+		}
+	}
+	return &models.Image{GalleryID: galleryID, Filename: filename, ContentType: "image/jpeg"}, nil
+}
+
+func (stub *stubImageService) ByGalleryID(galleryID uint) ([]models.Image, error) {
+	return stub.images, nil
+}
+
+func (stub *stubImageService) StatsByGalleryIDs(galleryIDs ...uint) (map[uint]models.ImageStats, error) {
+	return map[uint]models.ImageStats{}, nil
+}
+
+func (stub *stubImageService) CoversByGalleries(galleries ...models.Gallery) (map[uint]models.Image, error) {
+	return map[uint]models.Image{}, nil
+}
+
+func (stub *stubImageService) Delete(img *models.Image) error {
+	stub.deleted = append(stub.deleted, img.Filename)
+	return nil
+}
+
+func (stub *stubImageService) URL(img *models.Image, size int) string {
+	return ""
+}
+
+func (stub *stubImageService) Open(img *models.Image, size int) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader("pixels of " + img.Filename)), nil
+}
+
+type stubShareLinkService struct {
+	models.ShareLinkService
+	links map[string]models.ShareLink
+	views int
+}
+
+func (stub *stubShareLinkService) ByToken(token string) (*models.ShareLink, error) {
+	link, ok := stub.links[token]
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	if link.Expired() {
+		return nil, models.ErrShareLinkExpired
+	}
+	return &link, nil
+}
+
+func (stub *stubShareLinkService) RecordView(link *models.ShareLink) error {
+	stub.views++
+	return nil
+}
+
+func (stub *stubShareLinkService) ByGalleryID(galleryID uint) ([]models.ShareLink, error) {
+	var links []models.ShareLink
+	for _, link := range stub.links {
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+// stubMemberService gives users the roles in roles, in every gallery.
+type stubMemberService struct {
+	models.MemberService
+	roles map[uint]string
+}
+
+func (stub *stubMemberService) Role(galleryID, userID uint) (string, error) {
+	return stub.roles[userID], nil
+}
+
+func (stub *stubMemberService) ByGalleryID(galleryID uint) ([]models.GalleryMember, error) {
+	var members []models.GalleryMember
+	for userID, role := range stub.roles {
+		members = append(members, models.GalleryMember{GalleryID: galleryID, UserID: userID, Role: role})
+	}
+	return members, nil
+}
+
+func (stub *stubMemberService) ByUserID(userID uint) ([]models.GalleryMember, error) {
+	if role, ok := stub.roles[userID]; ok {
+		return []models.GalleryMember{{GalleryID: 1, UserID: userID, Role: role}}, nil
+	}
+	return nil, nil
+}
+
+// stubTagService keeps the tag names set on each gallery.
+type stubTagService struct {
+	models.TagService
+	galleries map[uint][]string
+}
+
+func (stub *stubTagService) ByGalleryIDs(galleryIDs ...uint) (map[uint][]models.Tag, error) {
+	result := make(map[uint][]models.Tag)
+	for _, id := range galleryIDs {
+		for _, name := range stub.galleries[id] {
+			result[id] = append(result[id], models.Tag{Name: name})
+		}
+	}
+	return result, nil
+}
+
+func (stub *stubTagService) ByImageIDs(imageIDs ...uint) (map[uint][]models.Tag, error) {
+	return map[uint][]models.Tag{}, nil
+}
+
+func (stub *stubTagService) SetGalleryTags(galleryID uint, names []string) ([]models.Tag, error) {
+	if stub.galleries == nil {
+		stub.galleries = make(map[uint][]string)
+	}
+	stub.galleries[galleryID] = nil
+	for _, name := range names {
+		if name = models.NormalizeTag(name); name != "" {
+			stub.galleries[galleryID] = append(stub.galleries[galleryID], name)
+		}
+	}
+	tags, _ := stub.ByGalleryIDs(galleryID)
+	return tags[galleryID], nil
+}
+
+type galleryStubs struct {
+	galleries   *stubGalleryService
+	images      *stubImageService
+	links       *stubShareLinkService
+	members     *stubMemberService
+	tags        *stubTagService
+	comments    *stubCommentService
+	collections *stubCollectionService
+	analytics   *stubAnalyticsService
+}
+
+func testingGalleryRouter(t *testing.T) (*mux.Router, galleryStubs) {
+	views.LayoutDir = "../views/layouts/"
+	views.TemplateDir = "../views/"
+	t.Cleanup(func() {
+		views.LayoutDir = "views/layouts/"
+		views.TemplateDir = "views/"
+	})
+
+	galleries := &stubGalleryService{gallery: models.Gallery{
+		UserID:     1,
+		Title:      "Test",
+		Visibility: models.VisibilityPublic,
+		PublicID:   "AAAAAAAAAAAAAAAAAAAAAA",
+	}}
+	galleries.gallery.ID = 1
+	images := &stubImageService{}
+	links := &stubShareLinkService{}
+	members := &stubMemberService{}
+	tags := &stubTagService{}
+	comments := &stubCommentService{}
+	collections := &stubCollectionService{}
+	analytics := &stubAnalyticsService{}
+
+	r := mux.NewRouter()
+	services := &models.Services{
+		Gallery:    galleries,
+		Image:      images,
+		ShareLink:  links,
+		Member:     members,
+		Tag:        tags,
+		Comment:    comments,
+		Collection: collections,
+		Analytics:  analytics,
+	}
+	galleryController := NewGalleryController(services, nil, r, 0)
+	r.HandleFunc("/galleries", galleryController.Index).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}", galleryController.Show).Methods("GET").Name(ShowGalleryRoute)
+	r.HandleFunc("/galleries/{id:[0-9]+}/download", galleryController.Download).Methods("GET")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}", galleryController.Show).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/unlock", galleryController.Unlock).Methods("POST")
+	r.HandleFunc("/s/{token}", galleryController.ShowShared).Methods("GET")
+	r.HandleFunc("/s/{token}/download", galleryController.DownloadShared).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", galleryController.Edit).Name(EditGalleryRoute)
+	r.HandleFunc("/galleries/{id:[0-9]+}/update", galleryController.Update).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", galleryController.Delete).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", galleryController.ReorderImages).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", galleryController.DeleteImage).Methods("POST")
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/{filename}", galleryController.ServeImage).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/comments", galleryController.CreateComment).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/comments/{comment_id:[0-9]+}/hide", galleryController.HideComment).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/comments/{comment_id:[0-9]+}/delete", galleryController.DeleteComment).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/collect", galleryController.CollectImage).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/uncollect", galleryController.UncollectImage).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/analytics", galleryController.Analytics).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/analytics.csv", galleryController.AnalyticsCSV).Methods("GET")
+	return r, galleryStubs{galleries, images, links, members, tags, comments, collections, analytics}
+}
+
+// testingRequest builds a request signed in as the user with userID,
+// or by a visitor when it is 0. A body is sent as a form.
+func testingRequest(method, path, body string, userID uint) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if userID != 0 {
+		user := &models.User{}
+		user.ID = userID
+		req = req.WithContext(context.WithUser(req.Context(), user))
+	}
+	return req
+}
+
+// serve records the response of r to req.
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// serveAs records the response of r to a testingRequest.
+func serveAs(r http.Handler, method, path, body string, userID uint) *httptest.ResponseRecorder {
+	return serve(r, testingRequest(method, path, body, userID))
+}
+
+// traversalPaths are filenames which decode to something other than a
+// generated image filename, including encoded and double encoded dots
+// and slashes.
+var traversalPaths = []string{
+	"..%2F..%2Fconfig%2Fconfig.json",
+	"..%252F..%252Fconfig%252Fconfig.json",
+	"%2e%2e",
+	"%252e%252e",
+	"%2e%2e%2fusers_test.go",
+	"..%5C..%5Cconfig.json",
+	"config.json",
+	validFilename + "%00.png",
+	strings.ToUpper(validFilename),
+}
+
+func TestServeImageRejectsTraversal(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	images := stubs.images
+
+	for _, filename := range traversalPaths {
+		req := httptest.NewRequest(http.MethodGet, "/images/galleries/1/"+filename, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			t.Errorf("GET %s: expected the request to be rejected. Received %d", filename, w.Code)
+		}
+	}
+	if len(images.requested) != 0 {
+		t.Errorf("Expected no lookups for invalid filenames. Received %q", images.requested)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/images/galleries/1/"+validFilename, nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "pixels of "+validFilename {
+		t.Errorf("Expected the image to be served. Received %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestDeleteImageRejectsTraversal(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	images := stubs.images
+	user := &models.User{}
+	user.ID = 1
+
+	for _, filename := range traversalPaths {
+		req := httptest.NewRequest(http.MethodPost, "/galleries/1/images/"+filename+"/delete", nil)
+		req = req.WithContext(context.WithUser(req.Context(), user))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code == http.StatusFound {
+			t.Errorf("POST %s: expected the request to be rejected", filename)
+		}
+	}
+	if len(images.requested) != 0 || len(images.deleted) != 0 {
+		t.Errorf("Expected no lookups for invalid filenames. Received %q", images.requested)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/galleries/1/images/"+validFilename+"/delete", nil)
+	req = req.WithContext(context.WithUser(req.Context(), user))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound || len(images.deleted) != 1 {
+		t.Errorf("Expected the image to be deleted. Received %d", w.Code)
+	}
+}
+
+func TestReorderImages(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	galleries, images := stubs.galleries, stubs.images
+	user := &models.User{}
+	user.ID = 1
+
+	second := strings.Replace(validFilename, "0", "1", 1)
+	body := "filenames=" + second + "&filenames=" + validFilename
+	req := httptest.NewRequest(http.MethodPost, "/galleries/1/images/order", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req = req.WithContext(context.WithUser(req.Context(), user))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected %d. Received %d %q", http.StatusNoContent, w.Code, w.Body.String())
+	}
+	if len(images.order) != 2 || images.order[0] != second || images.order[1] != validFilename {
+		t.Errorf("Expected the posted order. Received %q", images.order)
+	}
+	if len(galleries.updated) != 1 || galleries.updated[0].ImageSort != models.ImageSortManual {
+		t.Errorf("Expected the gallery to switch to manual sorting. Received %+v", galleries.updated)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/galleries/1/images/order", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	req = req.WithContext(context.WithUser(req.Context(), user))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an empty order to be rejected. Received %d", w.Code)
+	}
+
+	other := &models.User{}
+	other.ID = 2
+	req = httptest.NewRequest(http.MethodPost, "/galleries/1/images/order", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithUser(req.Context(), other))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected other users to be rejected. Received %d", w.Code)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	images := stubs.images
+	second := strings.Replace(validFilename, "0", "1", 1)
+	third := strings.Replace(validFilename, "0", "2", 1)
+	images.images = []models.Image{
+		{GalleryID: 1, Filename: validFilename, OriginalName: "beach.jpg"},
+		{GalleryID: 1, Filename: second, OriginalName: "BEACH.jpg"},
+		{GalleryID: 1, Filename: third, OriginalName: "../../etc/passwd"},
+	}
+
+	tests := []struct {
+		query    string
+		expected map[string]string
+	}{
+		{"", map[string]string{
+			"beach.jpg":          "pixels of " + validFilename,
+			"BEACH (2).jpg":      "pixels of " + second,
+			"_.._etc_passwd.jpg": "pixels of " + third,
+		}},
+		{"?files=" + third + "&files=" + validFilename, map[string]string{
+			"beach.jpg":          "pixels of " + validFilename,
+			"_.._etc_passwd.jpg": "pixels of " + third,
+		}},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/galleries/1/download"+test.query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+			t.Fatalf("GET %s: expected a zip archive. Received %d", test.query, w.Code)
+		}
+		archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(archive.File) != len(test.expected) {
+			t.Errorf("GET %s: expected %d entries. Received %d", test.query, len(test.expected), len(archive.File))
+		}
+		for _, file := range archive.File {
+			expected, ok := test.expected[file.Name]
+			if !ok {
+				t.Errorf("GET %s: unexpected entry %q", test.query, file.Name)
+				continue
+			}
+			contents, _ := file.Open()
+			data, _ := ioutil.ReadAll(contents)
+			if string(data) != expected {
+				t.Errorf("GET %s: %s: expected %q. Received %q", test.query, file.Name, expected, data)
+			}
+		}
+	}
+
+	for _, query := range []string{"?files=" + strings.Replace(validFilename, "0", "9", 1), "?files=..%2Fconfig.json"} {
+		req := httptest.NewRequest(http.MethodGet, "/galleries/1/download"+query, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: expected %d. Received %d", query, http.StatusNotFound, w.Code)
+		}
+	}
+}
+
+func TestGalleryVisibility(t *testing.T) {
+	owner := &models.User{}
+	owner.ID = 1
+	other := &models.User{}
+	other.ID = 2
+
+	tests := []struct {
+		visibility string
+		path       string
+		user       *models.User
+		expected   int
+	}{
+		{models.VisibilityPrivate, "/galleries/1", nil, http.StatusNotFound},
+		{models.VisibilityPrivate, "/galleries/1", other, http.StatusNotFound},
+		{models.VisibilityPrivate, "/galleries/1", owner, http.StatusOK},
+		{models.VisibilityPrivate, "/g/AAAAAAAAAAAAAAAAAAAAAA", nil, http.StatusNotFound},
+		{models.VisibilityPrivate, "/images/galleries/1/" + validFilename, nil, http.StatusNotFound},
+		{models.VisibilityPrivate, "/images/galleries/1/" + validFilename, owner, http.StatusOK},
+		{models.VisibilityUnlisted, "/galleries/1", other, http.StatusNotFound},
+		{models.VisibilityUnlisted, "/galleries/1/download", nil, http.StatusNotFound},
+		{models.VisibilityUnlisted, "/galleries/1", owner, http.StatusOK},
+		{models.VisibilityUnlisted, "/g/AAAAAAAAAAAAAAAAAAAAAA", nil, http.StatusOK},
+		{models.VisibilityUnlisted, "/g/BBBBBBBBBBBBBBBBBBBBBB", nil, http.StatusNotFound},
+		{models.VisibilityUnlisted, "/images/galleries/1/" + validFilename, nil, http.StatusOK},
+		{models.VisibilityPublic, "/galleries/1", nil, http.StatusOK},
+		{models.VisibilityPublic, "/galleries/1/download", other, http.StatusOK},
+	}
+	for _, test := range tests {
+		r, stubs := testingGalleryRouter(t)
+		stubs.galleries.gallery.Visibility = test.visibility
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.user != nil {
+			req = req.WithContext(context.WithUser(req.Context(), test.user))
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Errorf("%s gallery, GET %s as %v: expected %d. Received %d",
+				test.visibility, test.path, test.user != nil, test.expected, w.Code)
+		}
+	}
+}
+
+func TestShareLinks(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	stubs.galleries.gallery.Visibility = models.VisibilityPrivate
+	links := stubs.links
+	expired := time.Now().Add(-time.Hour)
+	links.links = map[string]models.ShareLink{
+		"1.view":     {GalleryID: 1, Token: "1.view"},
+		"2.download": {GalleryID: 1, Token: "2.download", AllowDownload: true},
+		"3.expired":  {GalleryID: 1, Token: "3.expired", ExpiresAt: &expired},
+		"4.other":    {GalleryID: 2, Token: "4.other"},
+	}
+
+	get := func(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/s/1.view")
+	if w.Code != http.StatusOK || links.views != 1 {
+		t.Fatalf("Expected the shared gallery to be shown and counted. Received %d, %d views", w.Code, links.views)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != shareCookieName || cookies[0].Path != "/images/galleries/1/" {
+		t.Fatalf("Expected a share cookie scoped to the gallery's images. Received %v", cookies)
+	}
+	if w := get("/images/galleries/1/"+validFilename, cookies[0]); w.Code != http.StatusOK {
+		t.Errorf("Expected the image to be served with the share cookie. Received %d", w.Code)
+	}
+	if w := get("/images/galleries/1/" + validFilename); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the image to be hidden without the share cookie. Received %d", w.Code)
+	}
+	if w := get("/images/galleries/1/"+validFilename, &http.Cookie{Name: shareCookieName, Value: "4.other"}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected links of other galleries to be refused. Received %d", w.Code)
+	}
+	if w := get("/galleries/1"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the gallery page to stay private. Received %d", w.Code)
+	}
+
+	if w := get("/s/1.view/download"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected downloads to be refused. Received %d", w.Code)
+	}
+	if w := get("/s/2.download/download"); w.Code != http.StatusOK {
+		t.Errorf("Expected downloads to be allowed. Received %d", w.Code)
+	}
+	if w := get("/s/3.expired"); w.Code != http.StatusGone {
+		t.Errorf("Expected expired links to be refused. Received %d", w.Code)
+	}
+	if w := get("/s/5.unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown links to be refused. Received %d", w.Code)
+	}
+}
+
+func TestGalleryPassword(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	stubs.galleries.gallery.PasswordHash = "hash"
+
+	request := func(method, path string, body io.Reader, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, body)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(http.MethodGet, "/galleries/1", nil)
+	if !strings.Contains(w.Body.String(), `action="/galleries/1/unlock"`) {
+		t.Errorf("Expected the password form in place of the gallery. Received %s", w.Body.String())
+	}
+	if w := request(http.MethodGet, "/images/galleries/1/"+validFilename, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected images of locked galleries to be hidden. Received %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/galleries/1/download", nil); strings.Contains(w.Header().Get("Content-Type"), "zip") {
+		t.Errorf("Expected locked galleries not to be downloaded")
+	}
+
+	w = request(http.MethodPost, "/galleries/1/unlock", strings.NewReader("password=wrong"))
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Fatalf("Expected the wrong password to be refused. Received %d, %v", w.Code, w.Result().Cookies())
+	}
+
+	w = request(http.MethodPost, "/galleries/1/unlock", strings.NewReader("password=secret"))
+	cookies := w.Result().Cookies()
+	if w.Code != http.StatusFound || len(cookies) != 1 || cookies[0].Name != "gallery_access_1" {
+		t.Fatalf("Expected an access cookie and a redirect. Received %d, %v", w.Code, cookies)
+	}
+	if w := request(http.MethodGet, "/images/galleries/1/"+validFilename, nil, cookies[0]); w.Code != http.StatusOK {
+		t.Errorf("Expected images to be served once unlocked. Received %d", w.Code)
+	}
+	if w := request(http.MethodGet, "/galleries/1", nil, cookies[0]); strings.Contains(w.Body.String(), "/unlock") {
+		t.Errorf("Expected the gallery to be shown once unlocked")
+	}
+}
+
+func TestGalleryRoles(t *testing.T) {
+	member := &models.User{}
+	member.ID = 2
+
+	tests := []struct {
+		role     string
+		method   string
+		path     string
+		expected int
+	}{
+		{"", http.MethodGet, "/galleries/1", http.StatusNotFound},
+		{"", http.MethodGet, "/galleries/1/edit", http.StatusNotFound},
+		{models.RoleViewer, http.MethodGet, "/galleries/1", http.StatusOK},
+		{models.RoleViewer, http.MethodGet, "/images/galleries/1/" + validFilename, http.StatusOK},
+		{models.RoleViewer, http.MethodGet, "/galleries/1/edit", http.StatusForbidden},
+		{models.RoleContributor, http.MethodGet, "/galleries/1/edit", http.StatusOK},
+		{models.RoleContributor, http.MethodPost, "/galleries/1/images/" + validFilename + "/delete", http.StatusForbidden},
+		{models.RoleEditor, http.MethodPost, "/galleries/1/images/" + validFilename + "/delete", http.StatusFound},
+		{models.RoleEditor, http.MethodPost, "/galleries/1/delete", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r, stubs := testingGalleryRouter(t)
+		stubs.galleries.gallery.Visibility = models.VisibilityPrivate
+		stubs.members.roles = map[uint]string{member.ID: test.role}
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req = req.WithContext(context.WithUser(req.Context(), member))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Errorf("%q member, %s %s: expected %d. Received %d", test.role, test.method, test.path, test.expected, w.Code)
+		}
+	}
+
+	// editors can rename the gallery but not change who can see it
+	r, stubs := testingGalleryRouter(t)
+	stubs.galleries.gallery.Visibility = models.VisibilityPrivate
+	stubs.members.roles = map[uint]string{member.ID: models.RoleEditor}
+	req := httptest.NewRequest(http.MethodPost, "/galleries/1/update", strings.NewReader("title=Renamed&visibility=public"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithUser(req.Context(), member))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(stubs.galleries.updated) != 1 {
+		t.Fatalf("Expected the gallery to be updated. Received %v", stubs.galleries.updated)
+	}
+	if updated := stubs.galleries.updated[0]; updated.Title != "Renamed" || updated.Visibility != models.VisibilityPrivate {
+		t.Errorf("Expected only the title to change. Received %q, %q", updated.Title, updated.Visibility)
+	}
+
+	// invalid tags are rejected before anything is saved
+	req = httptest.NewRequest(http.MethodPost, "/galleries/1/update", strings.NewReader("title=Again&tags=beach,sun%21"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithUser(req.Context(), member))
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(stubs.galleries.updated) != 1 || stubs.tags.galleries[1] != nil {
+		t.Errorf("Expected invalid tags to leave the gallery unchanged. Received %v, %v", stubs.galleries.updated, stubs.tags.galleries)
+	}
+}
+
+func TestUpdateKeepsEditPage(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	stubs.tags.galleries = map[uint][]string{1: {"beach"}}
+	stubs.links.links = map[string]models.ShareLink{"token": {GalleryID: 1, Label: "Family"}}
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{"title=Test&tags=beach,sun%21", `value="beach, sun!"`},
+		{"title=&tags=beach,summer", `value="beach, summer"`},
+	}
+	for _, test := range tests {
+		body := serveAs(r, http.MethodPost, "/galleries/1/update", test.body, 1).Body.String()
+		if !strings.Contains(body, test.expected) || !strings.Contains(body, "Family") {
+			t.Errorf("%s: expected the typed tags and the share links. Received %s", test.body, body)
+		}
+	}
+	if len(stubs.galleries.updated) != 0 || len(stubs.tags.galleries[1]) != 1 {
+		t.Errorf("Expected the gallery to be unchanged. Received %v, %v", stubs.galleries.updated, stubs.tags.galleries)
+	}
+
+	// saving shows the share links as well
+	body := serveAs(r, http.MethodPost, "/galleries/1/update", "title=Test&tags=beach", 1).Body.String()
+	if !strings.Contains(body, "succesfully updated") || !strings.Contains(body, "Family") {
+		t.Errorf("Expected the saved gallery with its share links. Received %s", body)
+	}
+}
+
+func TestGalleryIndexPages(t *testing.T) {
+	r, stubs := testingGalleryRouter(t)
+	stubs.members.roles = map[uint]string{2: models.RoleEditor}
+	member := &models.User{}
+	member.ID = 2
+
+	req := httptest.NewRequest(http.MethodGet, "/galleries?sort=title&order=asc&after=abc", nil)
+	req = req.WithContext(context.WithUser(req.Context(), member))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d. Received %d", http.StatusOK, w.Code)
+	}
+	expected := models.PageQuery{Sort: models.SortTitle, Order: models.OrderAsc, After: "abc"}
+	if stubs.galleries.query != expected {
+		t.Errorf("Expected %+v. Received %+v", expected, stubs.galleries.query)
+	}
+	body := w.Body.String()
+	for _, link := range []string{
+		`href="/galleries?after=next&amp;order=asc&amp;sort=title"`,
+		`href="/galleries?before=prev&amp;order=asc&amp;sort=title"`,
+		`href="/galleries?order=desc&amp;sort=title"`,
+		`href="/galleries?sort=created"`,
+		"<td>" + models.RoleEditor + "</td>",
+	} {
+		if !strings.Contains(body, link) {
+			t.Errorf("Expected the page to contain %s", link)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const galleryAccessTTL = 24 * time.Hour
+
+type UnlockGalleryForm struct {
+	Password string `schema:"password"`
+}
+
+// POST /galleries/:id/unlock
+// POST /g/:public_id/unlock
+// POST /s/:token/unlock
+func (galleryController *GalleryController) Unlock(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form UnlockGalleryForm
+
+	var gallery *models.Gallery
+	var err error
+	if _, ok := mux.Vars(r)["token"]; ok {
+		gallery, err = galleryController.fetchSharedGallery(w, r)
+	} else {
+		gallery, err = galleryController.fetchVisibleGallery(w, r)
+	}
+	viewData.Yield = gallery
+	if err != nil {
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.PasswordView.Render(w, r, viewData)
+		return
+	}
+	if err := galleryController.galleryService.Unlock(gallery, form.Password); err != nil {
+		viewData.SetAlert(err)
+		galleryController.PasswordView.Render(w, r, viewData)
+		return
+	}
+
+	expiresAt := time.Now().Add(galleryAccessTTL)
+	cookie := http.Cookie{
+		Name:     galleryAccessCookieName(gallery),
+		Value:    galleryController.galleryService.AccessToken(gallery, expiresAt),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, gallery.Path(), http.StatusFound)
+}
+
+// unlocked reports whether the visitor may see the contents of the
+// gallery, having entered its password if it has one. Members don't
+// need the password.
+func (galleryController *GalleryController) unlocked(r *http.Request, gallery *models.Gallery) bool {
+	if !gallery.HasPassword() || gallery.Can(models.PermissionView) {
+		return true
+	}
+	cookie, err := r.Cookie(galleryAccessCookieName(gallery))
+	if err != nil {
+		return false
+	}
+	return galleryController.galleryService.ValidAccessToken(gallery, cookie.Value)
+}
+
+// requireUnlocked renders the password form in place of galleries the
+// visitor hasn't unlocked yet, reporting whether to carry on.
+func (galleryController *GalleryController) requireUnlocked(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) bool {
+	if galleryController.unlocked(r, gallery) {
+		return true
+	}
+	var viewData views.Data
+	viewData.Yield = gallery
+	galleryController.PasswordView.Render(w, r, viewData)
+	return false
+}
+
+// galleryAccessCookieName is unique to each gallery, so that unlocking
+// one gallery leaves the cookies of others alone.
+func galleryAccessCookieName(gallery *models.Gallery) string {
+	return "gallery_access_" + strconv.Itoa(int(gallery.ID))
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"errors"
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// errForbidden is returned once a request has been refused because of
+// the user's role in a gallery.
+var errForbidden = errors.New("controllers: permission denied")
+
+type MemberForm struct {
+	Email string `schema:"email"`
+	Role  string `schema:"role"`
+}
+
+type InvitationForm struct {
+	Token string `schema:"token"`
+}
+
+// POST /galleries/:id/members
+//
+// Invites someone to the gallery by email. They become a member once
+// they accept the invitation, whether or not they already have an
+// account.
+func (galleryController *GalleryController) InviteMember(w http.ResponseWriter, r *http.Request) {
+	var form MemberForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		galleryController.renderMemberError(w, r, gallery, err)
+		return
+	}
+	user := context.User(r.Context())
+	if strings.EqualFold(strings.TrimSpace(form.Email), user.Email) {
+		galleryController.renderMemberError(w, r, gallery, models.ErrInviteOwner)
+		return
+	}
+	member := models.GalleryMember{
+		GalleryID:   gallery.ID,
+		Email:       form.Email,
+		Role:        form.Role,
+		InvitedByID: user.ID,
+	}
+	if err := galleryController.memberService.Create(&member); err != nil {
+		galleryController.renderMemberError(w, r, gallery, err)
+		return
+	}
+	go galleryController.emailClient.SendInvitationMessage(member.Email, user.Name, gallery.Title, member.Role, member.Token)
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Invitation sent to " + member.Email + ".",
+	})
+}
+
+// POST /galleries/:id/members/:member_id/update
+func (galleryController *GalleryController) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	var form MemberForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+	member, err := galleryController.fetchMember(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		galleryController.renderMemberError(w, r, gallery, err)
+		return
+	}
+	member.Role = form.Role
+	if err := galleryController.memberService.Update(member); err != nil {
+		galleryController.renderMemberError(w, r, gallery, err)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: member.Email + " is now " + member.Role + ".",
+	})
+}
+
+// POST /galleries/:id/members/:member_id/delete
+//
+// Removes a member, or cancels their invitation.
+func (galleryController *GalleryController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+	member, err := galleryController.fetchMember(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if err := galleryController.memberService.Delete(member.ID); err != nil {
+		galleryController.renderMemberError(w, r, gallery, err)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: member.Email + " was removed from the gallery.",
+	})
+}
+
+// GET /invitations/accept
+func (galleryController *GalleryController) Invitation(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form InvitationForm
+	viewData.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+	}
+	galleryController.InvitationView.Render(w, r, viewData)
+}
+
+// POST /invitations/accept
+func (galleryController *GalleryController) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form InvitationForm
+	viewData.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.InvitationView.Render(w, r, viewData)
+		return
+	}
+
+	member, err := galleryController.memberService.Accept(form.Token, context.User(r.Context()))
+	if err != nil {
+		viewData.SetAlert(err)
+		galleryController.InvitationView.Render(w, r, viewData)
+		return
+	}
+
+	url, err := galleryController.router.Get(ShowGalleryRoute).URL("id", strconv.Itoa(int(member.GalleryID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Invitation accepted. You are now " + member.Role + " of this gallery.",
+	})
+}
+
+// fetchMember looks up the member of the request, which has to belong
+// to gallery.
+func (galleryController *GalleryController) fetchMember(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.GalleryMember, error) {
+	memberID, err := strconv.Atoi(mux.Vars(r)["member_id"])
+	if err != nil {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return nil, err
+	}
+	member, err := galleryController.memberService.ByID(uint(memberID))
+	if err != nil || member.GalleryID != gallery.ID {
+		if err != nil && err != models.ErrNotFound {
+			log.Println(err)
+		}
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return nil, models.ErrNotFound
+	}
+	return member, nil
+}
+
+// renderMemberError shows the edit page of the gallery with err.
+func (galleryController *GalleryController) renderMemberError(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, err error) {
+	var viewData views.Data
+	viewData.SetAlert(err)
+	galleryController.renderEdit(w, r, viewData, gallery)
+}
+
+// loadMembers fills in the gallery's members for its edit page.
+// Failing to load them shouldn't keep the owner from editing.
+func (galleryController *GalleryController) loadMembers(gallery *models.Gallery) {
+	members, err := galleryController.memberService.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	gallery.Members = members
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"go-web-dev/views"
+)
+
+// PageForm holds the query parameters of paged listings.
+type PageForm struct {
+	Sort   string `schema:"sort"`
+	Order  string `schema:"order"`
+	After  string `schema:"after"`
+	Before string `schema:"before"`
+}
+
+func (form *PageForm) query() models.PageQuery {
+	return models.PageQuery{
+		Sort:   form.Sort,
+		Order:  form.Order,
+		After:  form.After,
+		Before: form.Before,
+	}
+}
+
+// newPagination describes page, a page of the listing served at path,
+// for the layout's pager.
+func newPagination(path string, sorts []string, page *models.Page) *views.Pagination {
+	return &views.Pagination{
+		Path:  path,
+		Sorts: sorts,
+		Sort:  page.Sort,
+		Order: page.Order,
+		Next:  page.Next,
+		Prev:  page.Prev,
+	}
+}
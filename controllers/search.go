@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"net/http"
+)
+
+func NewSearchController(searchService models.SearchService) *SearchController {
+	return &SearchController{
+		SearchView:    views.NewView("bootstrap", "search/results"),
+		searchService: searchService,
+	}
+}
+
+type SearchController struct {
+	SearchView    *views.View
+	searchService models.SearchService
+}
+
+type SearchForm struct {
+	Query string `schema:"q"`
+}
+
+// GET /search?q=
+//
+// Searches the titles, descriptions and tags of galleries and the
+// captions and tags of images which the current user may see listed.
+func (searchController *SearchController) Search(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form SearchForm
+	results := &models.SearchResults{}
+	viewData.Yield = results
+	if err := parseURLParams(r, &form); err != nil {
+		viewData.SetAlert(err)
+		searchController.SearchView.Render(w, r, viewData)
+		return
+	}
+	results.Query = form.Query
+
+	var viewerID uint
+	if user := context.User(r.Context()); user != nil {
+		viewerID = user.ID
+	}
+	found, err := searchController.searchService.Search(form.Query, viewerID)
+	if err != nil {
+		viewData.SetAlert(err)
+		searchController.SearchView.Render(w, r, viewData)
+		return
+	}
+	found.Query = form.Query
+	viewData.Yield = found
+	searchController.SearchView.Render(w, r, viewData)
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type stubSearchService struct {
+	query    string
+	viewerID uint
+}
+
+func (stub *stubSearchService) Search(query string, viewerID uint) (*models.SearchResults, error) {
+	stub.query, stub.viewerID = query, viewerID
+	gallery := models.GalleryResult{Snippet: "a \x01beach\x02 <script>"}
+	gallery.Title = "Summer"
+	gallery.ID = 1
+	gallery.Visibility = models.VisibilityPublic
+	return &models.SearchResults{Galleries: []models.GalleryResult{gallery}}, nil
+}
+
+func TestSearch(t *testing.T) {
+	testingGalleryRouter(t)
+	searchService := &stubSearchService{}
+	searchController := NewSearchController(searchService)
+	user := &models.User{}
+	user.ID = 3
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=beach", nil)
+	req = req.WithContext(context.WithUser(req.Context(), user))
+	w := httptest.NewRecorder()
+	searchController.Search(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %d. Received %d", http.StatusOK, w.Code)
+	}
+	if searchService.query != "beach" || searchService.viewerID != user.ID {
+		t.Errorf("Expected a search for %q by %d. Received %q by %d", "beach", user.ID, searchService.query, searchService.viewerID)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "a <mark>beach</mark> &lt;script&gt;") {
+		t.Errorf("Expected the snippet to be escaped and highlighted. Received %s", body)
+	}
+	if !strings.Contains(body, `value="beach"`) {
+		t.Errorf("Expected the search box to keep the query")
+	}
+}
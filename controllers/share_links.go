@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// shareCookieName holds the share link token a visitor opened, scoped
+// to the images of the shared gallery so they can be served too.
+const shareCookieName = "share_token"
+
+type ShareLinkForm struct {
+	Label         string `schema:"label"`
+	ExpiresInDays int    `schema:"expires_in_days"`
+	AllowDownload bool   `schema:"allow_download"`
+}
+
+// POST /galleries/:id/links
+func (galleryController *GalleryController) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form ShareLinkForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	viewData.Yield = gallery
+	if err != nil {
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	link := models.ShareLink{
+		GalleryID:     gallery.ID,
+		Label:         form.Label,
+		AllowDownload: form.AllowDownload,
+	}
+	if form.ExpiresInDays != 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresInDays)
+		link.ExpiresAt = &expiresAt
+	}
+	if err := galleryController.shareLinkService.Create(&link); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Share link created. Anyone with the link can view this gallery.",
+	})
+}
+
+// POST /galleries/:id/links/:link_id/revoke
+func (galleryController *GalleryController) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionManage)
+	viewData.Yield = gallery
+	if err != nil {
+		return
+	}
+
+	linkID, err := strconv.Atoi(mux.Vars(r)["link_id"])
+	if err != nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	link, err := galleryController.shareLinkService.ByID(uint(linkID))
+	if err != nil || link.GalleryID != gallery.ID {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if err := galleryController.shareLinkService.Delete(link.ID); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Share link revoked.",
+	})
+}
+
+// GET /s/:token
+//
+// Shows a gallery to anyone holding a valid share link, whatever the
+// gallery's visibility, and lets them load its images.
+func (galleryController *GalleryController) ShowShared(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	gallery, err := galleryController.fetchSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+	galleryController.loadTags(gallery)
+	if err := galleryController.shareLinkService.RecordView(gallery.ShareLink); err != nil {
+		log.Println(err)
+	}
+	galleryController.recordEvent(r, gallery, models.EventView, 0)
+
+	cookie := http.Cookie{
+		Name:     shareCookieName,
+		Value:    gallery.ShareLink.Token,
+		Path:     "/images/galleries/" + strconv.Itoa(int(gallery.ID)) + "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if gallery.ShareLink.ExpiresAt != nil {
+		cookie.Expires = *gallery.ShareLink.ExpiresAt
+	}
+	http.SetCookie(w, &cookie)
+
+	viewData.Yield = &GalleryPage{Gallery: gallery}
+	galleryController.ShowView.Render(w, r, viewData)
+}
+
+// GET /s/:token/download
+func (galleryController *GalleryController) DownloadShared(w http.ResponseWriter, r *http.Request) {
+	gallery, err := galleryController.fetchSharedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !galleryController.requireUnlocked(w, r, gallery) {
+		return
+	}
+	if !gallery.CanDownload() {
+		http.Error(w, "Downloads are not allowed for this link", http.StatusForbidden)
+		return
+	}
+	galleryController.writeArchive(w, r, gallery)
+}
+
+// fetchSharedGallery looks up the gallery of the share link token in
+// the request, with its images.
+func (galleryController *GalleryController) fetchSharedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	link, err := galleryController.shareLinkService.ByToken(mux.Vars(r)["token"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		case models.ErrShareLinkExpired:
+			http.Error(w, "This link has expired", http.StatusGone)
+		default:
+			log.Println(err)
+			http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		}
+		return nil, err
+	}
+	gallery, err := galleryController.galleryService.ByID(link.GalleryID)
+	if err != nil {
+		return nil, galleryLookupError(w, err)
+	}
+	gallery.ShareLink = link
+	if err := galleryController.loadRole(w, r, gallery); err != nil {
+		return nil, err
+	}
+	if err := galleryController.loadImages(w, gallery); err != nil {
+		return nil, err
+	}
+	return gallery, nil
+}
+
+// sharedWith reports whether the request carries a valid share link
+// for the gallery.
+func (galleryController *GalleryController) sharedWith(r *http.Request, gallery *models.Gallery) bool {
+	cookie, err := r.Cookie(shareCookieName)
+	if err != nil {
+		return false
+	}
+	link, err := galleryController.shareLinkService.ByToken(cookie.Value)
+	if err != nil {
+		return false
+	}
+	return link.GalleryID == gallery.ID
+}
+
+// loadShareLinks fills in the gallery's share links for its edit page.
+// Failing to load them shouldn't keep the owner from editing.
+func (galleryController *GalleryController) loadShareLinks(gallery *models.Gallery) {
+	links, err := galleryController.shareLinkService.ByGalleryID(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	gallery.ShareLinks = links
+}
+
+func (galleryController *GalleryController) redirectToEdit(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, alert views.Alert) {
+	url, err := galleryController.router.Get(EditGalleryRoute).URL("id", strconv.Itoa(int(gallery.ID)))
+	if err != nil {
+		log.Println(err)
+		http.Redirect(w, r, "/galleries", http.StatusInternalServerError)
+		return
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// tagPageImages is the number of tagged images shown on a tag's page.
+const tagPageImages = 48
+
+func NewTagController(tagService models.TagService, galleryService models.GalleryService, imageService models.ImageService) *TagController {
+	return &TagController{
+		ShowView:       views.NewView("bootstrap", "tags/show"),
+		tagService:     tagService,
+		galleryService: galleryService,
+		imgService:     imageService,
+	}
+}
+
+type TagController struct {
+	ShowView       *views.View
+	tagService     models.TagService
+	galleryService models.GalleryService
+	imgService     models.ImageService
+}
+
+// TagPage is what a tag's page shows to the current user.
+type TagPage struct {
+	Tag       *models.Tag
+	Galleries []models.Gallery
+	Images    []models.Image
+}
+
+type ImageTagsForm struct {
+	Tags string `schema:"tags"`
+}
+
+// GET /tags/:tag
+//
+// Lists the galleries and images carrying the tag which the current
+// user may see listed: their own, those they are a member of and
+// public ones.
+func (tagController *TagController) Show(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+
+	name := mux.Vars(r)["tag"]
+	normalized := models.NormalizeTag(name)
+	if normalized == "" {
+		http.Error(w, "Tag not found", http.StatusNotFound)
+		return
+	}
+	if normalized != name {
+		http.Redirect(w, r, "/tags/"+url.PathEscape(normalized), http.StatusMovedPermanently)
+		return
+	}
+	tag, err := tagController.tagService.ByName(normalized)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Tag not found", http.StatusNotFound)
+			return
+		}
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	var viewerID uint
+	if user := context.User(r.Context()); user != nil {
+		viewerID = user.ID
+	}
+	page := TagPage{Tag: tag}
+	if err := tagController.tagService.CountVisible(tag, viewerID); err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	page.Galleries, err = tagController.galleryService.ByTagID(tag.ID, viewerID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	page.Images, err = tagController.imgService.ByTagID(tag.ID, viewerID, tagPageImages)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+
+	galleryIDs := make([]uint, len(page.Galleries))
+	for i := range page.Galleries {
+		galleryIDs[i] = page.Galleries[i].ID
+	}
+	stats, err := tagController.imgService.StatsByGalleryIDs(galleryIDs...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+		return
+	}
+	for i := range page.Galleries {
+		page.Galleries[i].Stats = stats[page.Galleries[i].ID]
+	}
+
+	viewData.Yield = &page
+	tagController.ShowView.Render(w, r, viewData)
+}
+
+// POST /galleries/:id/images/:filename/tags
+func (galleryController *GalleryController) TagImage(w http.ResponseWriter, r *http.Request) {
+	var viewData views.Data
+	var form ImageTagsForm
+	gallery, err := galleryController.fetchAuthorizedGallery(w, r, models.PermissionEdit)
+	viewData.Yield = gallery
+	if err != nil {
+		return
+	}
+
+	filename := mux.Vars(r)["filename"]
+	if !models.ValidFilename(filename) {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	img, err := galleryController.imgService.ByFilename(gallery.ID, filename)
+	if err != nil {
+		if err == models.ErrNotFound {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	if err := parseForm(r, &form); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+	if _, err := galleryController.tagService.SetImageTags(img.ID, models.ParseTags(form.Tags)); err != nil {
+		viewData.SetAlert(err)
+		galleryController.renderEdit(w, r, viewData, gallery)
+		return
+	}
+
+	galleryController.redirectToEdit(w, r, gallery, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: "Tags of " + img.OriginalName + " saved.",
+	})
+}
+
+// typedTags keeps tags as they were typed into a form, to show them
+// again when saving the form failed.
+func typedTags(input string) []models.Tag {
+	tags := []models.Tag{}
+	for _, name := range models.ParseTags(input) {
+		if name = strings.TrimSpace(name); name != "" {
+			tags = append(tags, models.Tag{Name: name})
+		}
+	}
+	return tags
+}
+
+// loadTags fills in the tags of the gallery and of its images.
+// Failing to load them shouldn't keep the gallery from being shown.
+func (galleryController *GalleryController) loadTags(gallery *models.Gallery) {
+	tags, err := galleryController.tagService.ByGalleryIDs(gallery.ID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	gallery.Tags = tags[gallery.ID]
+
+	imageIDs := make([]uint, len(gallery.Images))
+	for i := range gallery.Images {
+		imageIDs[i] = gallery.Images[i].ID
+	}
+	imageTags, err := galleryController.tagService.ByImageIDs(imageIDs...)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for i := range gallery.Images {
+		gallery.Images[i].Tags = imageTags[gallery.Images[i].ID]
+	}
+}
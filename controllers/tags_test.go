@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+type stubTagPageService struct {
+	models.TagService
+	tag models.Tag
+}
+
+func (stub *stubTagPageService) ByName(name string) (*models.Tag, error) {
+	if models.NormalizeTag(name) != stub.tag.Name {
+		return nil, models.ErrNotFound
+	}
+	tag := stub.tag
+	return &tag, nil
+}
+
+func (stub *stubTagPageService) CountVisible(tag *models.Tag, viewerID uint) error {
+	tag.GalleryCount = 1
+	return nil
+}
+
+func (stub *stubTagPageService) ByGalleryIDs(galleryIDs ...uint) (map[uint][]models.Tag, error) {
+	return map[uint][]models.Tag{}, nil
+}
+
+type stubTaggedGalleries struct {
+	models.GalleryService
+	galleries []models.Gallery
+}
+
+func (stub *stubTaggedGalleries) ByTagID(tagID uint, viewerID uint) ([]models.Gallery, error) {
+	return stub.galleries, nil
+}
+
+type stubTaggedImages struct {
+	models.ImageService
+}
+
+func (stub *stubTaggedImages) ByTagID(tagID uint, viewerID uint, limit int) ([]models.Image, error) {
+	return nil, nil
+}
+
+func (stub *stubTaggedImages) CoversByGalleries(galleries ...models.Gallery) (map[uint]models.Image, error) {
+	return map[uint]models.Image{}, nil
+}
+
+func (stub *stubTaggedImages) StatsByGalleryIDs(galleryIDs ...uint) (map[uint]models.ImageStats, error) {
+	return map[uint]models.ImageStats{}, nil
+}
+
+func TestTagPage(t *testing.T) {
+	testingGalleryRouter(t)
+	gallery := models.Gallery{Title: "Beach day", Visibility: models.VisibilityPublic}
+	gallery.ID = 1
+	tagController := NewTagController(
+		&stubTagPageService{tag: models.Tag{Name: "summer-2022"}},
+		&stubTaggedGalleries{galleries: []models.Gallery{gallery}},
+		&stubTaggedImages{})
+	r := mux.NewRouter()
+	r.HandleFunc("/tags/{tag}", tagController.Show).Methods("GET")
+
+	tests := []struct {
+		path     string
+		expected int
+		location string
+	}{
+		{"/tags/summer-2022", http.StatusOK, ""},
+		{"/tags/%23Summer%202022", http.StatusMovedPermanently, "/tags/summer-2022"},
+		{"/tags/winter", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.expected {
+			t.Errorf("GET %s: expected %d. Received %d", test.path, test.expected, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s: expected redirect to %q. Received %q", test.path, test.location, location)
+		}
+		if w.Code == http.StatusOK && !strings.Contains(w.Body.String(), "Beach day") {
+			t.Errorf("GET %s: expected the tagged gallery to be listed", test.path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"go-web-dev/context"
+	"go-web-dev/models"
+	"go-web-dev/views"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// NewTrashController creates a controller for the galleries users
+// deleted, which are purged once they have been in the trash for
+// retention. A zero retention keeps them until purged by hand.
+func NewTrashController(galleryService models.GalleryService, retention time.Duration) *TrashController {
+	return &TrashController{
+		TrashView:      views.NewView("bootstrap", "galleries/trash"),
+		galleryService: galleryService,
+		retention:      retention,
+	}
+}
+
+type TrashController struct {
+	TrashView      *views.View
+	galleryService models.GalleryService
+	retention      time.Duration
+}
+
+// TrashPage lists the galleries in the user's trash.
+type TrashPage struct {
+	Galleries []models.Gallery
+	Retention time.Duration
+}
+
+// RetentionDays is the number of days galleries stay in the trash, or
+// zero when they aren't purged automatically.
+func (page *TrashPage) RetentionDays() int {
+	return int(page.Retention / (24 * time.Hour))
+}
+
+// PurgeDate is when the gallery will be purged automatically.
+func (page *TrashPage) PurgeDate(gallery models.Gallery) time.Time {
+	if gallery.DeletedAt == nil {
+		return time.Time{}
+	}
+	return gallery.DeletedAt.Add(page.Retention)
+}
+
+// GET /galleries/trash
+func (trashController *TrashController) Index(w http.ResponseWriter, r *http.Request) {
+	trashController.render(w, r, nil)
+}
+
+// render shows the current user's trash, with an alert for err unless
+// it is nil.
+func (trashController *TrashController) render(w http.ResponseWriter, r *http.Request, err error) {
+	var viewData views.Data
+	if err != nil {
+		viewData.SetAlert(err)
+	}
+	user := context.User(r.Context())
+	galleries, err := trashController.galleryService.TrashedByUserID(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	viewData.Yield = &TrashPage{
+		Galleries: galleries,
+		Retention: trashController.retention,
+	}
+	trashController.TrashView.Render(w, r, viewData)
+}
+
+// POST /galleries/:id/restore
+func (trashController *TrashController) Restore(w http.ResponseWriter, r *http.Request) {
+	gallery, err := trashController.fetchTrashedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := trashController.galleryService.Restore(gallery.ID); err != nil {
+		trashController.render(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, gallery.Path(), http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: gallery.Title + " was restored.",
+	})
+}
+
+// POST /galleries/:id/purge
+//
+// Deletes the gallery and its images for good.
+func (trashController *TrashController) Purge(w http.ResponseWriter, r *http.Request) {
+	gallery, err := trashController.fetchTrashedGallery(w, r)
+	if err != nil {
+		return
+	}
+	if err := trashController.galleryService.Purge(gallery.ID); err != nil {
+		trashController.render(w, r, err)
+		return
+	}
+	views.RedirectAlert(w, r, "/galleries/trash", http.StatusFound, views.Alert{
+		Level:   views.AlertLevelSuccess,
+		Message: gallery.Title + " was deleted permanently.",
+	})
+}
+
+// fetchTrashedGallery looks up a gallery of the current user's trash.
+// Galleries in the trash of other users are reported as not found.
+func (trashController *TrashController) fetchTrashedGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid gallery ID", http.StatusNotFound)
+		return nil, err
+	}
+	gallery, err := trashController.galleryService.TrashedByID(uint(id))
+	if err == nil && gallery.UserID != context.User(r.Context()).ID {
+		err = models.ErrNotFound
+	}
+	switch err {
+	case nil:
+		return gallery, nil
+	case models.ErrNotFound:
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+	default:
+		log.Println(err)
+		http.Error(w, "Whoops! Something went wrong.", http.StatusInternalServerError)
+	}
+	return nil, err
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"go-web-dev/models"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type stubTrash struct {
+	models.GalleryService
+	trashed  models.Gallery
+	restored []uint
+	purged   []uint
+}
+
+func (stub *stubTrash) TrashedByID(id uint) (*models.Gallery, error) {
+	if id != stub.trashed.ID {
+		return nil, models.ErrNotFound
+	}
+	gallery := stub.trashed
+	return &gallery, nil
+}
+
+func (stub *stubTrash) TrashedByUserID(userID uint) ([]models.Gallery, error) {
+	if userID != stub.trashed.UserID {
+		return nil, nil
+	}
+	return []models.Gallery{stub.trashed}, nil
+}
+
+func (stub *stubTrash) Restore(id uint) error {
+	stub.restored = append(stub.restored, id)
+	return nil
+}
+
+func (stub *stubTrash) Purge(id uint) error {
+	stub.purged = append(stub.purged, id)
+	return nil
+}
+
+func TestTrash(t *testing.T) {
+	testingGalleryRouter(t)
+	deletedAt := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	trash := &stubTrash{trashed: models.Gallery{UserID: 1, Title: "Old", Visibility: models.VisibilityPrivate}}
+	trash.trashed.ID = 7
+	trash.trashed.DeletedAt = &deletedAt
+	trashController := NewTrashController(trash, 30*24*time.Hour)
+	r := mux.NewRouter()
+	r.HandleFunc("/galleries/trash", trashController.Index).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/restore", trashController.Restore).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/purge", trashController.Purge).Methods("POST")
+
+	w := serveAs(r, http.MethodGet, "/galleries/trash", "", 1)
+	if body := w.Body.String(); !strings.Contains(body, "Old") || !strings.Contains(body, "Mar 31, 2022") {
+		t.Errorf("Expected the trashed gallery and its purge date to be listed. Received %s", body)
+	}
+
+	// galleries in the trash of other users can't be touched
+	for _, path := range []string{"/galleries/7/restore", "/galleries/7/purge", "/galleries/8/purge"} {
+		if w := serveAs(r, http.MethodPost, path, "", 2); w.Code != http.StatusNotFound {
+			t.Errorf("POST %s by another user: expected %d. Received %d", path, http.StatusNotFound, w.Code)
+		}
+	}
+	if len(trash.restored) != 0 || len(trash.purged) != 0 {
+		t.Fatalf("Expected nothing to be restored or purged. Received %v, %v", trash.restored, trash.purged)
+	}
+
+	if w := serveAs(r, http.MethodPost, "/galleries/7/restore", "", 1); w.Code != http.StatusFound || w.Header().Get("Location") != "/galleries/7" {
+		t.Errorf("Expected a redirect to the restored gallery. Received %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serveAs(r, http.MethodPost, "/galleries/7/purge", "", 1); w.Code != http.StatusFound || w.Header().Get("Location") != "/galleries/trash" {
+		t.Errorf("Expected a redirect to the trash. Received %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if len(trash.restored) != 1 || len(trash.purged) != 1 {
+		t.Errorf("Expected the gallery to be restored and purged. Received %v, %v", trash.restored, trash.purged)
+	}
+}
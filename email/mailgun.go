@@ -2,7 +2,9 @@ package email
 
 import (
 	"fmt"
+	"html"
 	"net/url"
+	"strings"
 
 	mailgun "gopkg.in/mailgun/mailgun-go.v1"
 )
@@ -59,8 +61,59 @@ const (
 	<br/>
 	Best,<br/>
 	The DevOps Team`
+
+	invitationBaseURL      = "http://localhost:3000/invitations/accept"
+	invitationSubject      = "You've been invited to a Fakeoku gallery"
+	invitationTextTemplate = `Hi There!
+	
+	%s has invited you to the gallery "%s" as %s. To accept the
+	invitation, sign in or sign up with this email address and follow
+	the link below:
+	
+	%s
+	
+	If you weren't expecting this invitation, you can ignore this email.
+	
+	Best,
+	The DevOps Team`
+	invitationHTMLTemplate = `Hi There!<br/>
+	<br/>
+	%s has invited you to the gallery "%s" as %s. To accept the
+	invitation, sign in or sign up with this email address and follow
+	the link below:<br/>
+	<br/>
+	<a href="%s">%s</a><br/>
+	<br/>
+	If you weren't expecting this invitation, you can ignore this email.<br/>
+	<br/>
+	Best,<br/>
+	The DevOps Team`
+
+	commentsSubject      = "New comments on your Fakeoku galleries"
+	commentsTextTemplate = `Hi There!
+	
+	There are new comments on your galleries:
+	%s
+	Best,
+	The DevOps Team`
+	commentsHTMLTemplate = `Hi There!<br/>
+	<br/>
+	There are new comments on your galleries:<br/>
+	<br/>
+	%s
+	Best,<br/>
+	The DevOps Team`
 )
 
+// CommentNotice is a comment included in a comments message. URL links
+// to the image the comment is about.
+type CommentNotice struct {
+	GalleryTitle string
+	AuthorName   string
+	Body         string
+	URL          string
+}
+
 type Client struct {
 	from          string
 	mailgunClient mailgun.Mailgun
@@ -122,6 +175,32 @@ func (client *Client) SendResetPasswordMessage(recipientName string, recipientEm
 	return client.SendHTMLMessage(client.from, buildEmail(recipientName, recipientEmail), resetSubject, resetText, resetHTML)
 }
 
+// SendInvitationMessage invites the recipient to a gallery, with the
+// link to accept the invitation carrying its token.
+func (client *Client) SendInvitationMessage(recipientEmail string, inviterName string, galleryTitle string, role string, token string) error {
+	values := url.Values{}
+	values.Set("token", token)
+	acceptURL := invitationBaseURL + "?" + values.Encode()
+	invitationText := fmt.Sprintf(invitationTextTemplate, inviterName, galleryTitle, role, acceptURL)
+	invitationHTML := fmt.Sprintf(invitationHTMLTemplate, html.EscapeString(inviterName), html.EscapeString(galleryTitle), role, acceptURL, acceptURL)
+	return client.SendHTMLMessage(client.from, recipientEmail, invitationSubject, invitationText, invitationHTML)
+}
+
+// SendCommentsMessage tells the owner of galleries about the comments
+// left on them since the last message, so that a busy thread sends a
+// single email rather than one per comment.
+func (client *Client) SendCommentsMessage(recipientName string, recipientEmail string, comments []CommentNotice) error {
+	var commentsText, commentsHTML strings.Builder
+	for _, comment := range comments {
+		fmt.Fprintf(&commentsText, "\n%s on \"%s\":\n%s\n%s\n", comment.AuthorName, comment.GalleryTitle, comment.Body, comment.URL)
+		fmt.Fprintf(&commentsHTML, "%s on <a href=\"%s\">%s</a>:<br/>\n%s<br/>\n<br/>\n",
+			html.EscapeString(comment.AuthorName), comment.URL, html.EscapeString(comment.GalleryTitle), html.EscapeString(comment.Body))
+	}
+	text := fmt.Sprintf(commentsTextTemplate, commentsText.String())
+	htmlBody := fmt.Sprintf(commentsHTMLTemplate, commentsHTML.String())
+	return client.SendHTMLMessage(client.from, buildEmail(recipientName, recipientEmail), commentsSubject, text, htmlBody)
+}
+
 func buildEmail(name, email string) string {
 	if name == "" {
 		return email
@@ -0,0 +1,391 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoExif  = errors.New("exif: no exif data found")
+	ErrInvalid = errors.New("exif: malformed exif data")
+)
+
+const (
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagExposureTime       = 0x829a
+	tagFNumber            = 0x829d
+	tagISO                = 0x8827
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagFocalLength        = 0x920a
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+
+	typeByte      = 1
+	typeASCII     = 2
+	typeShort     = 3
+	typeLong      = 4
+	typeRational  = 5
+	typeUndefined = 7
+	typeSLong     = 9
+	typeSRational = 10
+
+	dateTimeFormat = "2006:01:02 15:04:05"
+	// guards against IFDs pointing at each other
+	maxIFDEntries = 1000
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// Info holds the metadata we care about from a photo. Fields missing
+// from the file are left as their zero value.
+type Info struct {
+	Make        string
+	Model       string
+	Orientation int
+	TakenAt     *time.Time
+	// ExposureTime is formatted the way photographers expect, e.g. 1/250
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+	Latitude     *float64
+	Longitude    *float64
+}
+
+// Decode extracts the exif metadata of a JPEG image.
+func Decode(jpeg []byte) (*Info, error) {
+	segment, _, err := findExif(jpeg)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTIFF(segment[len(exifHeader):])
+}
+
+// Strip returns a copy of a JPEG image with every exif segment removed,
+// including any location the photo was taken at. The image data itself
+// is left untouched.
+func Strip(jpeg []byte) ([]byte, error) {
+	var result bytes.Buffer
+	err := walkSegments(jpeg, func(marker byte, start, end int, payload []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			return true
+		}
+		result.Write(jpeg[start:end])
+		return true
+	}, func(rest int) {
+		result.Write(jpeg[rest:])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// findExif returns the payload of the first exif APP1 segment.
+func findExif(jpeg []byte) ([]byte, int, error) {
+	var found []byte
+	offset := -1
+	err := walkSegments(jpeg, func(marker byte, start, end int, payload []byte) bool {
+		if marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			found = payload
+			offset = start
+			return false
+		}
+		return true
+	}, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	if found == nil {
+		return nil, -1, ErrNoExif
+	}
+	return found, offset, nil
+}
+
+// walkSegments calls fn for every marker segment preceding the image
+// data of a JPEG, until fn returns false. rest, when not nil, is called
+// with the offset of the first byte following those segments.
+func walkSegments(jpeg []byte, fn func(marker byte, start, end int, payload []byte) bool, rest func(int)) error {
+	if len(jpeg) < 2 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return ErrInvalid
+	}
+	if !fn(0xd8, 0, 2, nil) {
+		return nil
+	}
+	pos := 2
+	for pos+4 <= len(jpeg) {
+		if jpeg[pos] != 0xff {
+			return ErrInvalid
+		}
+		marker := jpeg[pos+1]
+		// start of scan, the compressed image data follows
+		if marker == 0xda {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(jpeg[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(jpeg) {
+			return ErrInvalid
+		}
+		if !fn(marker, pos, end, jpeg[pos+4:end]) {
+			return nil
+		}
+		pos = end
+	}
+	if rest != nil {
+		rest(pos)
+	}
+	return nil
+}
+
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type ifdEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	values []byte
+}
+
+func decodeTIFF(data []byte) (*Info, error) {
+	if len(data) < 8 {
+		return nil, ErrInvalid
+	}
+	reader := tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		reader.order = binary.LittleEndian
+	case "MM":
+		reader.order = binary.BigEndian
+	default:
+		return nil, ErrInvalid
+	}
+	if reader.order.Uint16(data[2:]) != 42 {
+		return nil, ErrInvalid
+	}
+
+	ifd0, err := reader.readIFD(reader.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	info := Info{}
+	var dateTime, offset string
+	for _, entry := range ifd0 {
+		switch entry.tag {
+		case tagMake:
+			info.Make = reader.ascii(entry)
+		case tagModel:
+			info.Model = reader.ascii(entry)
+		case tagOrientation:
+			info.Orientation = int(reader.uint(entry, 0))
+		case tagDateTime:
+			dateTime = reader.ascii(entry)
+		case tagExifIFD:
+			exifIFD, err := reader.readIFD(reader.uint(entry, 0))
+			if err != nil {
+				continue
+			}
+			for _, exifEntry := range exifIFD {
+				switch exifEntry.tag {
+				case tagExposureTime:
+					info.ExposureTime = reader.exposure(exifEntry)
+				case tagFNumber:
+					info.FNumber = reader.rational(exifEntry, 0)
+				case tagISO:
+					info.ISO = int(reader.uint(exifEntry, 0))
+				case tagDateTimeOriginal:
+					dateTime = reader.ascii(exifEntry)
+				case tagOffsetTimeOriginal:
+					offset = reader.ascii(exifEntry)
+				case tagFocalLength:
+					info.FocalLength = reader.rational(exifEntry, 0)
+				}
+			}
+		case tagGPSIFD:
+			gpsIFD, err := reader.readIFD(reader.uint(entry, 0))
+			if err != nil {
+				continue
+			}
+			info.Latitude, info.Longitude = reader.location(gpsIFD)
+		}
+	}
+	if takenAt, ok := parseDateTime(dateTime, offset); ok {
+		info.TakenAt = &takenAt
+	}
+	return &info, nil
+}
+
+func (reader tiffReader) readIFD(offset uint32) ([]ifdEntry, error) {
+	if offset == 0 || int(offset)+2 > len(reader.data) {
+		return nil, ErrInvalid
+	}
+	count := int(reader.order.Uint16(reader.data[offset:]))
+	if count > maxIFDEntries || int(offset)+2+count*12 > len(reader.data) {
+		return nil, ErrInvalid
+	}
+	entries := make([]ifdEntry, 0, count)
+	for i := 0; i < count; i++ {
+		raw := reader.data[int(offset)+2+i*12:]
+		entry := ifdEntry{
+			tag:   reader.order.Uint16(raw),
+			typ:   reader.order.Uint16(raw[2:]),
+			count: reader.order.Uint32(raw[4:]),
+		}
+		size := typeSize(entry.typ) * int64(entry.count)
+		if size <= 0 || size > int64(len(reader.data)) {
+			continue
+		}
+		if size <= 4 {
+			entry.values = raw[8 : 8+size]
+		} else {
+			valueOffset := int64(reader.order.Uint32(raw[8:]))
+			if valueOffset+size > int64(len(reader.data)) {
+				continue
+			}
+			entry.values = reader.data[valueOffset : valueOffset+size]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func typeSize(typ uint16) int64 {
+	switch typ {
+	case typeByte, typeASCII, typeUndefined:
+		return 1
+	case typeShort:
+		return 2
+	case typeLong, typeSLong:
+		return 4
+	case typeRational, typeSRational:
+		return 8
+	default:
+		return 0
+	}
+}
+
+func (reader tiffReader) ascii(entry ifdEntry) string {
+	if entry.typ != typeASCII {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.values), "\x00"))
+}
+
+func (reader tiffReader) uint(entry ifdEntry, i int) uint32 {
+	switch entry.typ {
+	case typeShort:
+		if len(entry.values) >= (i+1)*2 {
+			return uint32(reader.order.Uint16(entry.values[i*2:]))
+		}
+	case typeLong:
+		if len(entry.values) >= (i+1)*4 {
+			return reader.order.Uint32(entry.values[i*4:])
+		}
+	}
+	return 0
+}
+
+func (reader tiffReader) fraction(entry ifdEntry, i int) (int64, int64, bool) {
+	if (entry.typ != typeRational && entry.typ != typeSRational) || len(entry.values) < (i+1)*8 {
+		return 0, 0, false
+	}
+	numerator := reader.order.Uint32(entry.values[i*8:])
+	denominator := reader.order.Uint32(entry.values[i*8+4:])
+	if entry.typ == typeSRational {
+		return int64(int32(numerator)), int64(int32(denominator)), denominator != 0
+	}
+	return int64(numerator), int64(denominator), denominator != 0
+}
+
+func (reader tiffReader) rational(entry ifdEntry, i int) float64 {
+	numerator, denominator, ok := reader.fraction(entry, i)
+	if !ok {
+		return 0
+	}
+	return float64(numerator) / float64(denominator)
+}
+
+func (reader tiffReader) exposure(entry ifdEntry) string {
+	numerator, denominator, ok := reader.fraction(entry, 0)
+	if !ok || numerator <= 0 {
+		return ""
+	}
+	if numerator >= denominator {
+		return fmt.Sprintf("%g", math.Round(float64(numerator)/float64(denominator)*10)/10)
+	}
+	return fmt.Sprintf("1/%d", int64(math.Round(float64(denominator)/float64(numerator))))
+}
+
+func (reader tiffReader) location(gpsIFD []ifdEntry) (*float64, *float64) {
+	var latitude, longitude *float64
+	latitudeRef, longitudeRef := "N", "E"
+	for _, entry := range gpsIFD {
+		switch entry.tag {
+		case tagGPSLatitudeRef:
+			latitudeRef = reader.ascii(entry)
+		case tagGPSLongitudeRef:
+			longitudeRef = reader.ascii(entry)
+		case tagGPSLatitude:
+			latitude = reader.degrees(entry)
+		case tagGPSLongitude:
+			longitude = reader.degrees(entry)
+		}
+	}
+	if latitude == nil || longitude == nil {
+		return nil, nil
+	}
+	if latitudeRef == "S" {
+		*latitude = -*latitude
+	}
+	if longitudeRef == "W" {
+		*longitude = -*longitude
+	}
+	if math.Abs(*latitude) > 90 || math.Abs(*longitude) > 180 {
+		return nil, nil
+	}
+	return latitude, longitude
+}
+
+// degrees converts a degrees, minutes, seconds triplet to decimal
+func (reader tiffReader) degrees(entry ifdEntry) *float64 {
+	if entry.count != 3 {
+		return nil
+	}
+	value := reader.rational(entry, 0) + reader.rational(entry, 1)/60 + reader.rational(entry, 2)/3600
+	return &value
+}
+
+// parseDateTime parses an exif timestamp, which has no time zone unless
+// accompanied by an offset such as +02:00. UTC is assumed otherwise.
+func parseDateTime(dateTime string, offset string) (time.Time, bool) {
+	if dateTime == "" {
+		return time.Time{}, false
+	}
+	location := time.UTC
+	if offset != "" {
+		if zone, err := time.Parse("-07:00", offset); err == nil {
+			location = zone.Location()
+		}
+	}
+	takenAt, err := time.ParseInLocation(dateTimeFormat, dateTime, location)
+	if err != nil || takenAt.Year() < 1800 {
+		return time.Time{}, false
+	}
+	return takenAt, true
+}
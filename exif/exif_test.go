@@ -0,0 +1,224 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	tag    uint16
+	typ    uint16
+	count  uint32
+	values []byte
+}
+
+func asciiEntry(tag uint16, value string) testEntry {
+	return testEntry{tag, typeASCII, uint32(len(value) + 1), append([]byte(value), 0)}
+}
+
+func shortEntry(tag uint16, value uint16) testEntry {
+	values := make([]byte, 2)
+	binary.LittleEndian.PutUint16(values, value)
+	return testEntry{tag, typeShort, 1, values}
+}
+
+func longEntry(tag uint16, value uint32) testEntry {
+	values := make([]byte, 4)
+	binary.LittleEndian.PutUint32(values, value)
+	return testEntry{tag, typeLong, 1, values}
+}
+
+func rationalEntry(tag uint16, fractions ...uint32) testEntry {
+	values := make([]byte, 4*len(fractions))
+	for i, value := range fractions {
+		binary.LittleEndian.PutUint32(values[i*4:], value)
+	}
+	return testEntry{tag, typeRational, uint32(len(fractions) / 2), values}
+}
+
+// buildTIFF lays out IFD0 followed by the exif and GPS IFDs, pointing
+// IFD0 at the other two.
+func buildTIFF(ifd0, exifIFD, gpsIFD []testEntry) []byte {
+	ifdSize := func(entries []testEntry) uint32 {
+		size := uint32(2 + len(entries)*12 + 4)
+		for _, entry := range entries {
+			if len(entry.values) > 4 {
+				size += uint32(len(entry.values))
+			}
+		}
+		return size
+	}
+	ifd0 = append(ifd0, longEntry(tagExifIFD, 0), longEntry(tagGPSIFD, 0))
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exifIFD)
+	binary.LittleEndian.PutUint32(ifd0[len(ifd0)-2].values, exifOffset)
+	binary.LittleEndian.PutUint32(ifd0[len(ifd0)-1].values, gpsOffset)
+
+	var buffer bytes.Buffer
+	buffer.WriteString("II")
+	binary.Write(&buffer, binary.LittleEndian, uint16(42))
+	binary.Write(&buffer, binary.LittleEndian, uint32(8))
+	for _, entries := range [][]testEntry{ifd0, exifIFD, gpsIFD} {
+		start := uint32(buffer.Len())
+		valueOffset := start + uint32(2+len(entries)*12+4)
+		var values bytes.Buffer
+		binary.Write(&buffer, binary.LittleEndian, uint16(len(entries)))
+		for _, entry := range entries {
+			binary.Write(&buffer, binary.LittleEndian, entry.tag)
+			binary.Write(&buffer, binary.LittleEndian, entry.typ)
+			binary.Write(&buffer, binary.LittleEndian, entry.count)
+			if len(entry.values) > 4 {
+				binary.Write(&buffer, binary.LittleEndian, valueOffset+uint32(values.Len()))
+				values.Write(entry.values)
+			} else {
+				padded := make([]byte, 4)
+				copy(padded, entry.values)
+				buffer.Write(padded)
+			}
+		}
+		binary.Write(&buffer, binary.LittleEndian, uint32(0))
+		buffer.Write(values.Bytes())
+	}
+	return buffer.Bytes()
+}
+
+// testingJPEG encodes a 4x2 image and inserts an exif segment right
+// after the start of image marker.
+func testingJPEG(t *testing.T, tiff []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	segment := append(append([]byte{}, exifHeader...), tiff...)
+	var result bytes.Buffer
+	result.Write(encoded.Bytes()[:2])
+	result.Write([]byte{0xff, 0xe1})
+	binary.Write(&result, binary.BigEndian, uint16(len(segment)+2))
+	result.Write(segment)
+	result.Write(encoded.Bytes()[2:])
+	return result.Bytes()
+}
+
+func testingTIFF() []byte {
+	return buildTIFF(
+		[]testEntry{
+			asciiEntry(tagMake, "Canon"),
+			asciiEntry(tagModel, "Canon EOS 5D Mark IV"),
+			shortEntry(tagOrientation, 6),
+		},
+		[]testEntry{
+			rationalEntry(tagExposureTime, 1, 250),
+			rationalEntry(tagFNumber, 28, 10),
+			shortEntry(tagISO, 400),
+			asciiEntry(tagDateTimeOriginal, "2021:07:04 18:30:15"),
+			asciiEntry(tagOffsetTimeOriginal, "-04:00"),
+			rationalEntry(tagFocalLength, 50, 1),
+		},
+		[]testEntry{
+			asciiEntry(tagGPSLatitudeRef, "S"),
+			rationalEntry(tagGPSLatitude, 33, 1, 51, 1, 3144, 100),
+			asciiEntry(tagGPSLongitudeRef, "E"),
+			rationalEntry(tagGPSLongitude, 151, 1, 12, 1, 3600, 100),
+		},
+	)
+}
+
+func TestDecode(t *testing.T) {
+	info, err := Decode(testingJPEG(t, testingTIFF()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Make != "Canon" || info.Model != "Canon EOS 5D Mark IV" {
+		t.Errorf("Expected Canon EOS 5D Mark IV. Received %s %s", info.Make, info.Model)
+	}
+	if info.Orientation != 6 {
+		t.Errorf("Expected orientation 6. Received %d", info.Orientation)
+	}
+	if info.ExposureTime != "1/250" || info.FNumber != 2.8 || info.ISO != 400 || info.FocalLength != 50 {
+		t.Errorf("Expected 1/250 f/2.8 ISO 400 50mm. Received %s f/%g ISO %d %gmm",
+			info.ExposureTime, info.FNumber, info.ISO, info.FocalLength)
+	}
+	expectedTime := time.Date(2021, time.July, 4, 22, 30, 15, 0, time.UTC)
+	if info.TakenAt == nil || !info.TakenAt.Equal(expectedTime) {
+		t.Errorf("Expected %s. Received %v", expectedTime, info.TakenAt)
+	}
+	if info.Latitude == nil || info.Longitude == nil {
+		t.Fatal("Expected a location")
+	}
+	if *info.Latitude > -33.8587 || *info.Latitude < -33.8588 || *info.Longitude < 151.2099 || *info.Longitude > 151.2101 {
+		t.Errorf("Expected -33.8587, 151.21. Received %f, %f", *info.Latitude, *info.Longitude)
+	}
+}
+
+func TestDecodeWithoutExif(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 4, 2)), nil)
+	if _, err := Decode(encoded.Bytes()); err != ErrNoExif {
+		t.Errorf("Expected ErrNoExif. Received %v", err)
+	}
+	if _, err := Decode([]byte("not a jpeg")); err != ErrInvalid {
+		t.Errorf("Expected ErrInvalid. Received %v", err)
+	}
+}
+
+func TestDecodeRejectsOutOfRangeOffsets(t *testing.T) {
+	tiff := testingTIFF()
+	// point IFD0 past the end of the data
+	binary.LittleEndian.PutUint32(tiff[4:], uint32(len(tiff)+100))
+	if _, err := Decode(testingJPEG(t, tiff)); err != ErrInvalid {
+		t.Errorf("Expected ErrInvalid. Received %v", err)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	original := testingJPEG(t, testingTIFF())
+	stripped, err := Strip(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, exifHeader) || bytes.Contains(stripped, []byte("Canon")) {
+		t.Error("Expected the exif segment to be removed")
+	}
+	if _, err := Decode(stripped); err != ErrNoExif {
+		t.Errorf("Expected ErrNoExif. Received %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 2 {
+		t.Errorf("Expected a 4x2 image. Received %v", img.Bounds())
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// a 3x2 image with a red top left pixel
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	red := color.RGBA{255, 0, 0, 255}
+	src.Set(0, 0, red)
+
+	expected := map[int]image.Point{
+		1: {0, 0},
+		2: {2, 0},
+		3: {2, 1},
+		4: {0, 1},
+		5: {0, 0},
+		6: {1, 0},
+		7: {1, 2},
+		8: {0, 2},
+	}
+	for orientation, point := range expected {
+		dst := Orient(src, orientation)
+		if orientation >= 5 && (dst.Bounds().Dx() != 2 || dst.Bounds().Dy() != 3) {
+			t.Errorf("Orientation %d: expected a 2x3 image. Received %v", orientation, dst.Bounds())
+		}
+		if dst.At(point.X, point.Y) != red {
+			t.Errorf("Orientation %d: expected the red pixel at %v", orientation, point)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"hash"
 )
 
+// NewHMAC returns an HMAC keyed by key. An HMAC reuses a single
+// hash.Hash and isn't safe for concurrent use, so code serving
+// concurrent requests creates a new one every time it hashes.
 func NewHMAC(key string) HMAC {
 	hmac := hmac.New(sha256.New, []byte(key))
 	return HMAC{
@@ -8,6 +8,7 @@ import (
 	"go-web-dev/middleware"
 	"go-web-dev/models"
 	"go-web-dev/rand"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/csrf"
@@ -18,17 +19,38 @@ import (
 func main() {
 	prodFlag := flag.Bool("prod", false, "Set to true in production. This ensures that a config file is provided.")
 	envFlag := flag.Bool("dbenv", false, "If true, reads database connection values from environment variables.")
+	reconcileFlag := flag.String("reconcile", "", "Set to \"report\" to list image files left behind by deleted galleries, or \"delete\" to remove them, then exit.")
 	flag.Parse()
 
 	appConfig := LoadConfig(*prodFlag, *envFlag)
+	if *reconcileFlag != "" {
+		if err := reconcile(appConfig, *reconcileFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	appConfig.LoadServiceSecrets()
+
+	imageStorage, err := appConfig.Storage.Storage()
+	if err != nil {
+		panic(err)
+	}
 
 	services, err := models.NewServices(
 		models.WithGormDB(appConfig.Database.Dialect(), appConfig.Database.ConnectionString()),
 		models.WithDBLogMode(!appConfig.IsProd()),
 		models.WithOAuthService(),
-		models.WithGalleryService(),
+		models.WithImageService(imageStorage, appConfig.Images.Limits(), appConfig.Images.VariantSizes...),
+		models.WithGalleryService(appConfig.Pepper, appConfig.HMACKey),
 		models.WithUserService(appConfig.Pepper, appConfig.HMACKey),
-		models.WithImageService(),
+		models.WithShareLinkService(appConfig.HMACKey),
+		models.WithMemberService(appConfig.HMACKey),
+		models.WithTagService(),
+		models.WithSearchService(),
+		models.WithCommentService(),
+		models.WithCollectionService(),
+		models.WithFollowService(),
+		models.WithAnalyticsService(appConfig.HMACKey),
 	)
 	if err != nil {
 		panic(err)
@@ -37,7 +59,11 @@ func main() {
 	// services.DestructiveReset()
 	services.AutoMigrate()
 
+	go purgeTrash(services.Gallery, appConfig.Trash.Retention(), appConfig.Trash.PurgeInterval())
+	go purgeVisits(services.Analytics, visitPurgeInterval)
+
 	emailClient := email.NewClient(email.WithMailgun(appConfig.Mailgun.APIKey, appConfig.Mailgun.PublicAPIKey, appConfig.Mailgun.Domain))
+	go notifyComments(services.Comment, emailClient, commentNotifyInterval)
 
 	configs := make(map[string]*oauth2.Config)
 	configs[models.OAuthDropbox] = &oauth2.Config{
@@ -55,7 +81,14 @@ func main() {
 	staticController := controllers.NewStaticController()
 	oauthController := controllers.NewOAuthController(services.OAuth, configs)
 	userController := controllers.NewUserController(services.User, emailClient)
-	galleriesController := controllers.NewGalleryController(services.Gallery, services.Image, r)
+	tagController := controllers.NewTagController(services.Tag, services.Gallery, services.Image)
+	searchController := controllers.NewSearchController(services.Search)
+	exploreController := controllers.NewExploreController(services.Gallery, services.Image, services.Tag, services.User)
+	collectionController := controllers.NewCollectionController(services.Collection)
+	followController := controllers.NewFollowController(services.Follow, services.User, services.Gallery, services.Image, services.Tag)
+	feedController := controllers.NewFeedController(services.User, services.Gallery, services.Image)
+	trashController := controllers.NewTrashController(services.Gallery, appConfig.Trash.Retention())
+	galleriesController := controllers.NewGalleryController(services, emailClient, r, appConfig.Images.MaxRequestBytes)
 
 	// login middleware
 	userExists := middleware.UserExists{
@@ -68,6 +101,7 @@ func main() {
 	// create mux router - routes requests to controllers
 	r.Handle("/", staticController.HomeView).Methods("GET")
 	r.Handle("/contact", staticController.ContactView).Methods("GET")
+	r.HandleFunc("/explore", exploreController.Index).Methods("GET")
 	// users
 	r.HandleFunc("/signup", userController.New).Methods("GET")
 	r.HandleFunc("/signup", userController.Create).Methods("POST")
@@ -78,6 +112,12 @@ func main() {
 	r.HandleFunc("/password/forgot", userController.InitiateReset).Methods("POST")
 	r.HandleFunc("/password/reset", userController.ResetPassword).Methods("GET")
 	r.HandleFunc("/password/reset", userController.PerformReset).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}", followController.Profile).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/feed.atom", feedController.Atom).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/feed.rss", feedController.RSS).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/follow", userVerification.ApplyFn(followController.Follow)).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}/unfollow", userVerification.ApplyFn(followController.Unfollow)).Methods("POST")
+	r.HandleFunc("/feed", userVerification.ApplyFn(followController.Feed)).Methods("GET")
 	// dropbox
 	r.HandleFunc("/oauth/{service_name:[A-Za-z0-9]+}/connect", userVerification.ApplyFn(oauthController.Connect)).Methods("GET")
 	r.HandleFunc("/oauth/{service_name:[A-Za-z0-9]+}/callback", userVerification.ApplyFn(oauthController.Callback)).Methods("GET")
@@ -87,14 +127,61 @@ func main() {
 	r.HandleFunc("/galleries", userVerification.ApplyFn(galleriesController.Index)).Methods("GET")
 	r.HandleFunc("/galleries", userVerification.ApplyFn(galleriesController.Create)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesController.Show).Methods("GET").Name(controllers.ShowGalleryRoute)
+	r.HandleFunc("/galleries/{id:[0-9]+}/download", galleriesController.Download).Methods("GET")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}", galleriesController.Show).Methods("GET")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}/download", galleriesController.Download).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/unlock", galleriesController.Unlock).Methods("POST")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}/unlock", galleriesController.Unlock).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/edit", userVerification.ApplyFn(galleriesController.Edit)).Methods("GET").Name(controllers.EditGalleryRoute)
 	r.HandleFunc("/galleries/{id:[0-9]+}/update", userVerification.ApplyFn(galleriesController.Update)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/images", userVerification.ApplyFn(galleriesController.UploadImage)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/order", userVerification.ApplyFn(galleriesController.ReorderImages)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", userVerification.ApplyFn(galleriesController.DeleteImage)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/cover", userVerification.ApplyFn(galleriesController.SetCover)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/tags", userVerification.ApplyFn(galleriesController.TagImage)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/caption", userVerification.ApplyFn(galleriesController.CaptionImage)).Methods("POST")
 	r.HandleFunc("/galleries/{id:[0-9]+}/delete", userVerification.ApplyFn(galleriesController.Delete)).Methods("POST")
-	// serve local image files
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
+	r.HandleFunc("/galleries/{id:[0-9]+}/analytics", userVerification.ApplyFn(galleriesController.Analytics)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/analytics.csv", userVerification.ApplyFn(galleriesController.AnalyticsCSV)).Methods("GET")
+	// comments
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/comments", userVerification.ApplyFn(galleriesController.CreateComment)).Methods("POST")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}/images/{filename}/comments", userVerification.ApplyFn(galleriesController.CreateComment)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/comments/{comment_id:[0-9]+}/hide", userVerification.ApplyFn(galleriesController.HideComment)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/comments/{comment_id:[0-9]+}/delete", userVerification.ApplyFn(galleriesController.DeleteComment)).Methods("POST")
+	// trash
+	r.HandleFunc("/galleries/trash", userVerification.ApplyFn(trashController.Index)).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/restore", userVerification.ApplyFn(trashController.Restore)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/purge", userVerification.ApplyFn(trashController.Purge)).Methods("POST")
+	// collections
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/collect", userVerification.ApplyFn(galleriesController.CollectImage)).Methods("POST")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}/images/{filename}/collect", userVerification.ApplyFn(galleriesController.CollectImage)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/uncollect", userVerification.ApplyFn(galleriesController.UncollectImage)).Methods("POST")
+	r.HandleFunc("/g/{public_id:[A-Za-z0-9_-]+}/images/{filename}/uncollect", userVerification.ApplyFn(galleriesController.UncollectImage)).Methods("POST")
+	r.HandleFunc("/collections", userVerification.ApplyFn(collectionController.Index)).Methods("GET")
+	r.HandleFunc("/collections", userVerification.ApplyFn(collectionController.Create)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}", userVerification.ApplyFn(collectionController.Show)).Methods("GET")
+	r.HandleFunc("/collections/{id:[0-9]+}/update", userVerification.ApplyFn(collectionController.Update)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/delete", userVerification.ApplyFn(collectionController.Delete)).Methods("POST")
+	r.HandleFunc("/collections/{id:[0-9]+}/images/{image_id:[0-9]+}/remove", userVerification.ApplyFn(collectionController.RemoveImage)).Methods("POST")
+	// share links
+	r.HandleFunc("/galleries/{id:[0-9]+}/links", userVerification.ApplyFn(galleriesController.CreateShareLink)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{link_id:[0-9]+}/revoke", userVerification.ApplyFn(galleriesController.RevokeShareLink)).Methods("POST")
+	r.HandleFunc("/s/{token:[0-9]+\\.[A-Za-z0-9_-]+}", galleriesController.ShowShared).Methods("GET")
+	r.HandleFunc("/s/{token:[0-9]+\\.[A-Za-z0-9_-]+}/download", galleriesController.DownloadShared).Methods("GET")
+	r.HandleFunc("/s/{token:[0-9]+\\.[A-Za-z0-9_-]+}/unlock", galleriesController.Unlock).Methods("POST")
+	// members
+	r.HandleFunc("/galleries/{id:[0-9]+}/members", userVerification.ApplyFn(galleriesController.InviteMember)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/members/{member_id:[0-9]+}/update", userVerification.ApplyFn(galleriesController.UpdateMember)).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}/members/{member_id:[0-9]+}/delete", userVerification.ApplyFn(galleriesController.RemoveMember)).Methods("POST")
+	r.HandleFunc("/invitations/accept", userVerification.ApplyFn(galleriesController.Invitation)).Methods("GET")
+	r.HandleFunc("/invitations/accept", userVerification.ApplyFn(galleriesController.AcceptInvitation)).Methods("POST")
+	// tags
+	r.HandleFunc("/tags/{tag}", tagController.Show).Methods("GET")
+	// search
+	r.HandleFunc("/search", searchController.Search).Methods("GET")
+	// images are streamed from the storage backend
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/{filename}", galleriesController.ServeImage).Methods("GET")
+	r.HandleFunc("/images/galleries/{id:[0-9]+}/variants/{size:[0-9]+}/{filename}", galleriesController.ServeImage).Methods("GET")
 	// serve static assets
 	assetHandler := http.FileServer(http.Dir("./assets/"))
 	r.PathPrefix("/assets/").Handler(http.StripPrefix("/assets/", assetHandler))
@@ -0,0 +1,117 @@
+// Package markdown renders Markdown written by users into HTML that is
+// safe to embed in our pages.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// linkRel is given to every link, as they lead to pages users chose.
+const linkRel = "nofollow noopener noreferrer"
+
+// safeSchemes are the URL schemes links and images may use. URLs
+// without a scheme are relative and always allowed.
+var safeSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// converter leaves out raw HTML, which is goldmark's default, so
+// nothing but the Markdown itself can produce tags.
+var converter = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+	goldmark.WithParserOptions(
+		parser.WithASTTransformers(util.Prioritized(safeLinks{}, 100)),
+	),
+	goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// Render converts source to HTML. Raw HTML is dropped, links and
+// images with URLs other than http, https, mailto or relative ones
+// are reduced to their text, and links are marked nofollow.
+func Render(source string) template.HTML {
+	var buf bytes.Buffer
+	if err := converter.Convert([]byte(source), &buf); err != nil {
+		// writing to a buffer doesn't fail, but should the parser
+		// ever do so the text is still worth showing
+		return template.HTML("<p>" + template.HTMLEscapeString(source) + "</p>")
+	}
+	return template.HTML(buf.String())
+}
+
+// safeLinks is an AST transformer which replaces links and images
+// with unsafe URLs by their text, and sets rel on the others.
+type safeLinks struct{}
+
+func (safeLinks) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var unsafe []ast.Node
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			if !safeURL(n.Destination) {
+				unsafe = append(unsafe, n)
+				break
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.AutoLink:
+			if n.AutoLinkType == ast.AutoLinkURL && !safeURL(n.URL(source)) {
+				unsafe = append(unsafe, n)
+				break
+			}
+			n.SetAttributeString("rel", []byte(linkRel))
+		case *ast.Image:
+			if !safeURL(n.Destination) {
+				unsafe = append(unsafe, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, node := range unsafe {
+		parent := node.Parent()
+		switch n := node.(type) {
+		case *ast.AutoLink:
+			parent.ReplaceChild(parent, n, ast.NewString(n.Label(source)))
+		case *ast.Image:
+			parent.ReplaceChild(parent, n, ast.NewString(n.Text(source)))
+		default:
+			for child := n.FirstChild(); child != nil; child = n.FirstChild() {
+				parent.InsertBefore(parent, n, child)
+			}
+			parent.RemoveChild(parent, n)
+		}
+	}
+}
+
+// safeURL reports whether the URL is relative or uses a safe scheme.
+// Browsers ignore whitespace and control characters within schemes,
+// so those are removed before looking at it.
+func safeURL(destination []byte) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, string(destination))
+	u, err := url.Parse(cleaned)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "" || safeSchemes[strings.ToLower(u.Scheme)]
+}
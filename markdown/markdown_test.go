@@ -0,0 +1,55 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		source   string
+		contains []string
+		excludes []string
+	}{
+		{
+			source:   "Shot on **film** in _Lisbon_",
+			contains: []string{"<strong>film</strong>", "<em>Lisbon</em>"},
+		},
+		{
+			source:   "Hello <script>alert(1)</script> <img src=x onerror=alert(1)>",
+			excludes: []string{"<script", "<img", "onerror"},
+		},
+		{
+			source:   "[site](https://example.com)",
+			contains: []string{`<a href="https://example.com" rel="nofollow noopener noreferrer">site</a>`},
+		},
+		{
+			source:   "[click](javascript:alert(1)) [again](JavaScript:alert(1)) [tab](<java\tscript:alert(1)>)",
+			contains: []string{"click", "again", "tab"},
+			excludes: []string{"<a", "script:"},
+		},
+		{
+			source:   "<javascript:alert(1)> and www.example.com",
+			contains: []string{`<a href="http://www.example.com" rel="nofollow noopener noreferrer">`},
+			excludes: []string{`href="javascript`},
+		},
+		{
+			source:   "![pixel](data:text/html;base64,PHNjcmlwdD4=) ![photo](/images/galleries/1/a.jpg)",
+			contains: []string{"pixel", `<img src="/images/galleries/1/a.jpg" alt="photo">`},
+			excludes: []string{"data:"},
+		},
+	}
+	for _, test := range tests {
+		html := string(Render(test.source))
+		for _, expected := range test.contains {
+			if !strings.Contains(html, expected) {
+				t.Errorf("Render(%q) = %q, expected it to contain %q", test.source, html, expected)
+			}
+		}
+		for _, unexpected := range test.excludes {
+			if strings.Contains(html, unexpected) {
+				t.Errorf("Render(%q) = %q, expected it not to contain %q", test.source, html, unexpected)
+			}
+		}
+	}
+}
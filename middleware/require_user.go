@@ -17,9 +17,10 @@ func (userExists *UserExists) Apply(next http.Handler) http.HandlerFunc {
 
 func (userExists *UserExists) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// static assets are not blocked behind login
+		// static assets are not blocked behind login. Images are not
+		// skipped as private galleries only serve them to their owner.
 		path := r.URL.Path
-		if strings.HasPrefix(path, "/assets/") || strings.HasPrefix(path, "/images/") {
+		if strings.HasPrefix(path, "/assets/") {
 			next(w, r)
 			return
 		}
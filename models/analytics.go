@@ -0,0 +1,308 @@
+package models
+
+import (
+	"go-web-dev/hash"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	EventView      = "view"
+	EventImageView = "image_view"
+	EventDownload  = "download"
+
+	// statVisitors is the kind of the stats counting the visitors of
+	// a gallery, whatever they did
+	statVisitors = "visitors"
+
+	// maxAnalyticsDays is the longest period analytics are reported
+	// for at once
+	maxAnalyticsDays = 366
+)
+
+// Event is something a visitor did with a gallery: viewing its page,
+// opening one of its images at full size, or downloading it. Visitor
+// identifies who it was, such as their address and user agent, and is
+// only stored as a keyed hash which changes every day.
+type Event struct {
+	Kind      string
+	GalleryID uint
+	ImageID   uint
+	Visitor   string
+	At        time.Time
+}
+
+// galleryVisit records that a visitor caused an event on a day, so
+// that they are only counted once among its visitors. Visits are only
+// needed on the day they happen and are purged after.
+type galleryVisit struct {
+	Day       time.Time `gorm:"type:date;primary_key"`
+	GalleryID uint      `gorm:"primary_key;auto_increment:false"`
+	ImageID   uint      `gorm:"primary_key;auto_increment:false"`
+	Kind      string    `gorm:"primary_key"`
+	Visitor   string    `gorm:"primary_key"`
+}
+
+// GalleryStat counts the visitors who caused an event of a kind on a
+// gallery, or on one of its images, during a day. Each visitor is
+// counted once however often they caused it.
+type GalleryStat struct {
+	GalleryID uint      `gorm:"primary_key;auto_increment:false"`
+	ImageID   uint      `gorm:"primary_key;auto_increment:false"`
+	Day       time.Time `gorm:"type:date;primary_key"`
+	Kind      string    `gorm:"primary_key"`
+	Count     int       `gorm:"not null"`
+}
+
+// DailyStats sums up the events of a gallery on a day. Visitors are
+// those who caused any of them.
+type DailyStats struct {
+	Day        time.Time
+	Views      int
+	Visitors   int
+	ImageViews int
+	Downloads  int
+}
+
+// Add adds the counts of other to stats.
+func (stats *DailyStats) Add(other DailyStats) {
+	stats.Views += other.Views
+	stats.Visitors += other.Visitors
+	stats.ImageViews += other.ImageViews
+	stats.Downloads += other.Downloads
+}
+
+// ImageViews is how often an image was opened, counting each visitor
+// once a day.
+type ImageViews struct {
+	Image
+	Views int
+}
+
+type AnalyticsService interface {
+	AnalyticsDB
+}
+
+func NewAnalyticsService(db *gorm.DB, hmacKey string) AnalyticsService {
+	return &analyticsService{
+		AnalyticsDB: &analyticsValidator{
+			AnalyticsDB: &analyticsGorm{db},
+			hmacKey:     hmacKey,
+		},
+	}
+}
+
+type analyticsService struct {
+	AnalyticsDB
+}
+
+var _ AnalyticsDB = &analyticsValidator{}
+
+type analyticsValidator struct {
+	AnalyticsDB
+	hmacKey string
+}
+
+func (aValidator *analyticsValidator) Record(event *Event) error {
+	err := runEventValFuncs(event,
+		aValidator.requireGalleryID,
+		aValidator.validateKind,
+		aValidator.defaultAt,
+		aValidator.hashVisitor)
+	if err != nil {
+		return err
+	}
+	return aValidator.AnalyticsDB.Record(event)
+}
+
+// Daily covers the days from from to to, both included.
+func (aValidator *analyticsValidator) Daily(galleryID uint, from, to time.Time) ([]DailyStats, error) {
+	from, to, err := analyticsPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return aValidator.AnalyticsDB.Daily(galleryID, from, to)
+}
+
+func (aValidator *analyticsValidator) TopImages(galleryID uint, from, to time.Time, limit int) ([]ImageViews, error) {
+	from, to, err := analyticsPeriod(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return aValidator.AnalyticsDB.TopImages(galleryID, from, to, limit)
+}
+
+type eventValFunc func(*Event) error
+
+func runEventValFuncs(event *Event, funcs ...eventValFunc) error {
+	for _, function := range funcs {
+		if err := function(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (aValidator *analyticsValidator) requireGalleryID(event *Event) error {
+	if event.GalleryID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+func (aValidator *analyticsValidator) validateKind(event *Event) error {
+	switch event.Kind {
+	case EventView, EventDownload:
+		event.ImageID = 0
+	case EventImageView:
+		if event.ImageID <= 0 {
+			return ErrInvalidID
+		}
+	default:
+		return ErrInvalidEvent
+	}
+	return nil
+}
+
+func (aValidator *analyticsValidator) defaultAt(event *Event) error {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	return nil
+}
+
+// hashVisitor replaces the visitor with a hash keyed by the day, so
+// that visitors can't be told apart from one day to the next or traced
+// back to their address.
+func (aValidator *analyticsValidator) hashVisitor(event *Event) error {
+	if event.Visitor == "" {
+		return ErrRequiredVisitor
+	}
+	day := analyticsDay(event.At).Format("2006-01-02")
+	visitor := hash.NewHMAC(aValidator.hmacKey).Hash("visitor:" + day + ":" + event.Visitor)
+	event.Visitor = strings.TrimRight(visitor, "=")
+	return nil
+}
+
+// analyticsDay is the day t falls on. Days are counted in UTC.
+func analyticsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// analyticsPeriod rounds a period to whole days, rejecting those which
+// are backwards or too long.
+func analyticsPeriod(from, to time.Time) (time.Time, time.Time, error) {
+	from, to = analyticsDay(from), analyticsDay(to)
+	if to.Before(from) || to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		return from, to, ErrInvalidPeriod
+	}
+	return from, to, nil
+}
+
+type AnalyticsDB interface {
+	// Record counts the event and its visitor, unless they caused the
+	// same event, or any event on the gallery, that day already.
+	Record(event *Event) error
+	// Daily returns the gallery's stats for every day of the period,
+	// including those without any events.
+	Daily(galleryID uint, from, to time.Time) ([]DailyStats, error)
+	// TopImages returns the gallery's images which were opened most
+	// often during the period.
+	TopImages(galleryID uint, from, to time.Time, limit int) ([]ImageViews, error)
+	// PurgeVisits forgets who visited before the given day, which only
+	// mattered to count them once that day, and returns how many
+	// visits were deleted.
+	PurgeVisits(before time.Time) (int64, error)
+}
+
+var _ AnalyticsDB = &analyticsGorm{}
+
+type analyticsGorm struct {
+	db *gorm.DB
+}
+
+func (aGorm *analyticsGorm) Record(event *Event) error {
+	tx := aGorm.db.Begin()
+	err := countVisit(tx, event, event.Kind, event.ImageID)
+	if err == nil {
+		err = countVisit(tx, event, statVisitors, 0)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// countVisit adds the event's visitor to the stats of kind, unless
+// they were counted that day already.
+func countVisit(tx *gorm.DB, event *Event, kind string, imageID uint) error {
+	day := analyticsDay(event.At)
+	visit := tx.Exec(`INSERT INTO gallery_visits (day, gallery_id, image_id, kind, visitor)
+		VALUES (?, ?, ?, ?, ?) ON CONFLICT DO NOTHING`,
+		day, event.GalleryID, imageID, kind, event.Visitor)
+	if visit.Error != nil || visit.RowsAffected == 0 {
+		return visit.Error
+	}
+	return tx.Exec(`INSERT INTO gallery_stats (gallery_id, image_id, day, kind, count)
+		VALUES (?, ?, ?, ?, 1)
+		ON CONFLICT (gallery_id, image_id, day, kind) DO UPDATE
+		SET count = gallery_stats.count + 1`,
+		event.GalleryID, imageID, day, kind).Error
+}
+
+func (aGorm *analyticsGorm) Daily(galleryID uint, from, to time.Time) ([]DailyStats, error) {
+	var stats []GalleryStat
+	err := aGorm.db.Table("gallery_stats").
+		Select("day, kind, sum(count) AS count").
+		Where("gallery_id = ? AND day >= ? AND day <= ?", galleryID, from, to).
+		Group("day, kind").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]DailyStats, int(to.Sub(from)/(24*time.Hour))+1)
+	for i := range days {
+		days[i].Day = from.AddDate(0, 0, i)
+	}
+	for _, stat := range stats {
+		i := int(analyticsDay(stat.Day).Sub(from) / (24 * time.Hour))
+		if i < 0 || i >= len(days) {
+			continue
+		}
+		switch stat.Kind {
+		case EventView:
+			days[i].Views += stat.Count
+		case statVisitors:
+			days[i].Visitors += stat.Count
+		case EventImageView:
+			days[i].ImageViews += stat.Count
+		case EventDownload:
+			days[i].Downloads += stat.Count
+		}
+	}
+	return days, nil
+}
+
+// TopImages leaves out images which have since been deleted.
+func (aGorm *analyticsGorm) TopImages(galleryID uint, from, to time.Time, limit int) ([]ImageViews, error) {
+	var images []ImageViews
+	err := aGorm.db.Table("gallery_stats").
+		Select("images.*, sum(gallery_stats.count) AS views").
+		Joins("JOIN images ON images.id = gallery_stats.image_id AND images.deleted_at IS NULL").
+		Where("gallery_stats.gallery_id = ? AND gallery_stats.kind = ?", galleryID, EventImageView).
+		Where("gallery_stats.day >= ? AND gallery_stats.day <= ?", from, to).
+		Group("images.id").
+		Order("views DESC, images.id").
+		Limit(limit).
+		Scan(&images).Error
+	return images, err
+}
+
+func (aGorm *analyticsGorm) PurgeVisits(before time.Time) (int64, error) {
+	db := aGorm.db.Where("day < ?", analyticsDay(before)).Delete(&galleryVisit{})
+	return db.RowsAffected, db.Error
+}
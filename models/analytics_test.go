@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func testingAnalyticsService() (AnalyticsService, error) {
+	services, err := testingServices(WithAnalyticsService("test-key"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Clear tables
+	services.db.DropTableIfExists(&galleryVisit{}, &GalleryStat{})
+	services.db.AutoMigrate(&galleryVisit{}, &GalleryStat{})
+	return services.Analytics, nil
+}
+
+func TestRecordCountsVisitorsOnce(t *testing.T) {
+	analyticsService, err := testingAnalyticsService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Kind: EventView, Visitor: "a"},
+		{Kind: EventView, Visitor: "a"},
+		{Kind: EventView, Visitor: "b"},
+		{Kind: EventDownload, Visitor: "a"},
+		{Kind: EventDownload, Visitor: "a"},
+		{Kind: EventImageView, ImageID: 7, Visitor: "a"},
+		{Kind: EventImageView, ImageID: 7, Visitor: "a"},
+		{Kind: EventView, Visitor: "a", At: day.AddDate(0, 0, 1)},
+	}
+	for _, event := range events {
+		event.GalleryID = 1
+		if event.At.IsZero() {
+			event.At = day
+		}
+		if err := analyticsService.Record(&event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	days, err := analyticsService.Daily(1, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 2 {
+		t.Fatalf("Expected 2 days. Received %d", len(days))
+	}
+	expected := DailyStats{Day: analyticsDay(day), Views: 2, Visitors: 2, ImageViews: 1, Downloads: 1}
+	if !days[0].Day.Equal(expected.Day) || days[0].Views != expected.Views || days[0].Visitors != expected.Visitors ||
+		days[0].ImageViews != expected.ImageViews || days[0].Downloads != expected.Downloads {
+		t.Errorf("Expected %+v. Received %+v", expected, days[0])
+	}
+	if days[1].Views != 1 || days[1].Visitors != 1 {
+		t.Errorf("Expected the visitor to be counted again the next day. Received %+v", days[1])
+	}
+}
@@ -0,0 +1,392 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// maxCollectionNameLen is the number of characters in the name of
+	// a collection
+	maxCollectionNameLen = 64
+	// DefaultCollectionName names the collection images are added to
+	// when the user doesn't pick one
+	DefaultCollectionName = "Favorites"
+)
+
+// Collection is a user's personal board of images, which may come
+// from any gallery they can see. ImageCount and Cover only account
+// for the images the user may still see, and are filled in by
+// ByUserID.
+type Collection struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	ImageCount int    `gorm:"-"`
+	Cover      *Image `gorm:"-"`
+}
+
+// Path is the page listing the images of the collection.
+func (collection *Collection) Path() string {
+	return "/collections/" + strconv.Itoa(int(collection.ID))
+}
+
+// CollectionImage adds an image to a collection.
+type CollectionImage struct {
+	CollectionID uint `gorm:"primary_key;auto_increment:false"`
+	ImageID      uint `gorm:"primary_key;auto_increment:false;index"`
+	CreatedAt    time.Time
+}
+
+// CollectionItem is an image in a collection along with the gallery
+// it belongs to, which only has the fields needed to link to it.
+type CollectionItem struct {
+	Image
+	Gallery Gallery
+	AddedAt time.Time
+}
+
+type CollectionService interface {
+	CollectionDB
+}
+
+func NewCollectionService(db *gorm.DB) CollectionService {
+	return &collectionService{
+		CollectionDB: &collectionValidator{
+			CollectionDB: &collectionGorm{db},
+		},
+	}
+}
+
+type collectionService struct {
+	CollectionDB
+}
+
+var _ CollectionDB = &collectionValidator{}
+
+type collectionValidator struct {
+	CollectionDB
+}
+
+func (cValidator *collectionValidator) Create(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cValidator.requireUserID,
+		cValidator.normalizeName,
+		cValidator.requireName,
+		cValidator.nameLength)
+	if err != nil {
+		return err
+	}
+	return cValidator.CollectionDB.Create(collection)
+}
+
+func (cValidator *collectionValidator) Update(collection *Collection) error {
+	err := runCollectionValFuncs(collection,
+		cValidator.validateID,
+		cValidator.normalizeName,
+		cValidator.requireName,
+		cValidator.nameLength)
+	if err != nil {
+		return err
+	}
+	return cValidator.CollectionDB.Update(collection)
+}
+
+func (cValidator *collectionValidator) Delete(id uint) error {
+	var collection Collection
+	collection.ID = id
+	if err := runCollectionValFuncs(&collection, cValidator.validateID); err != nil {
+		return err
+	}
+	return cValidator.CollectionDB.Delete(collection.ID)
+}
+
+func (cValidator *collectionValidator) ByName(userID uint, name string) (*Collection, error) {
+	collection := Collection{UserID: userID, Name: name}
+	err := runCollectionValFuncs(&collection,
+		cValidator.requireUserID,
+		cValidator.normalizeName,
+		cValidator.requireName)
+	if err != nil {
+		return nil, err
+	}
+	return cValidator.CollectionDB.ByName(collection.UserID, collection.Name)
+}
+
+func (cValidator *collectionValidator) AddImage(collectionID, imageID uint) error {
+	if collectionID <= 0 || imageID <= 0 {
+		return ErrInvalidID
+	}
+	return cValidator.CollectionDB.AddImage(collectionID, imageID)
+}
+
+func (cValidator *collectionValidator) RemoveImage(collectionID, imageID uint) error {
+	if collectionID <= 0 || imageID <= 0 {
+		return ErrInvalidID
+	}
+	return cValidator.CollectionDB.RemoveImage(collectionID, imageID)
+}
+
+type collectionValFunc func(*Collection) error
+
+func runCollectionValFuncs(collection *Collection, funcs ...collectionValFunc) error {
+	for _, function := range funcs {
+		if err := function(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cValidator *collectionValidator) requireUserID(collection *Collection) error {
+	if collection.UserID <= 0 {
+		return ErrRequiredUserID
+	}
+	return nil
+}
+
+func (cValidator *collectionValidator) normalizeName(collection *Collection) error {
+	collection.Name = strings.Join(strings.Fields(collection.Name), " ")
+	return nil
+}
+
+func (cValidator *collectionValidator) requireName(collection *Collection) error {
+	if collection.Name == "" {
+		return ErrRequiredCollectionName
+	}
+	return nil
+}
+
+func (cValidator *collectionValidator) nameLength(collection *Collection) error {
+	if utf8.RuneCountInString(collection.Name) > maxCollectionNameLen {
+		return ErrCollectionNameTooLong
+	}
+	return nil
+}
+
+func (cValidator *collectionValidator) validateID(collection *Collection) error {
+	if collection.ID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+type CollectionDB interface {
+	Create(collection *Collection) error
+	Update(collection *Collection) error
+	Delete(id uint) error
+
+	ByID(id uint) (*Collection, error)
+	// ByName finds the user's collection named name, ignoring case.
+	ByName(userID uint, name string) (*Collection, error)
+	// ByUserID returns the user's collections by name.
+	ByUserID(userID uint) ([]Collection, error)
+	// Items returns the images of the collection which viewerID may
+	// see, most recently added first. Images whose gallery has since
+	// been deleted, made private or protected by a password are left
+	// out.
+	Items(collectionID, viewerID uint) ([]CollectionItem, error)
+
+	// AddImage adds the image to the collection, unless it is in it
+	// already.
+	AddImage(collectionID, imageID uint) error
+	RemoveImage(collectionID, imageID uint) error
+
+	// Containing returns the IDs of userID's collections holding each
+	// image, keyed by image ID.
+	Containing(userID uint, imageIDs ...uint) (map[uint][]uint, error)
+	// FavoriteCounts returns the number of users who collected each
+	// image, keyed by image ID.
+	FavoriteCounts(imageIDs ...uint) (map[uint]int, error)
+}
+
+var _ CollectionDB = &collectionGorm{}
+
+type collectionGorm struct {
+	db *gorm.DB
+}
+
+func (cGorm *collectionGorm) Create(collection *Collection) error {
+	return cGorm.db.Create(collection).Error
+}
+
+func (cGorm *collectionGorm) Update(collection *Collection) error {
+	return cGorm.db.Save(collection).Error
+}
+
+func (cGorm *collectionGorm) Delete(id uint) error {
+	tx := cGorm.db.Begin()
+	err := tx.Where("collection_id = ?", id).Delete(&CollectionImage{}).Error
+	if err == nil {
+		err = tx.Delete(&Collection{Model: gorm.Model{ID: id}}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (cGorm *collectionGorm) ByID(id uint) (*Collection, error) {
+	var collection Collection
+	err := first(cGorm.db.Where("id = ?", id), &collection)
+	return &collection, err
+}
+
+func (cGorm *collectionGorm) ByName(userID uint, name string) (*Collection, error) {
+	var collection Collection
+	db := cGorm.db.Where("user_id = ? AND lower(name) = lower(?)", userID, name).Order("id")
+	err := first(db, &collection)
+	return &collection, err
+}
+
+// ByUserID also fills in the number of images the user may still see
+// in each collection, and the one added last as its cover.
+func (cGorm *collectionGorm) ByUserID(userID uint) ([]Collection, error) {
+	var collections []Collection
+	err := cGorm.db.Where("user_id = ?", userID).Order("lower(name), id").Find(&collections).Error
+	if err != nil || len(collections) == 0 {
+		return collections, err
+	}
+	ids := make([]uint, len(collections))
+	for i := range collections {
+		ids[i] = collections[i].ID
+	}
+
+	var counts []struct {
+		CollectionID uint
+		Count        int
+	}
+	err = cGorm.viewableItems(userID).
+		Select("collection_images.collection_id, count(*) AS count").
+		Where("collection_images.collection_id IN (?)", ids).
+		Group("collection_images.collection_id").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	var covers []struct {
+		Image
+		CollectionID uint
+	}
+	err = cGorm.viewableItems(userID).
+		Select("DISTINCT ON (collection_images.collection_id) images.*, collection_images.collection_id").
+		Where("collection_images.collection_id IN (?)", ids).
+		Order("collection_images.collection_id, collection_images.created_at DESC, images.id").
+		Scan(&covers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint]*Collection, len(collections))
+	for i := range collections {
+		byID[collections[i].ID] = &collections[i]
+	}
+	for _, count := range counts {
+		byID[count.CollectionID].ImageCount = count.Count
+	}
+	for _, cover := range covers {
+		img := cover.Image
+		byID[cover.CollectionID].Cover = &img
+	}
+	return collections, nil
+}
+
+func (cGorm *collectionGorm) Items(collectionID, viewerID uint) ([]CollectionItem, error) {
+	var rows []struct {
+		Image
+		GalleryTitle      string
+		GalleryVisibility string
+		GalleryPublicID   string
+		AddedAt           time.Time
+	}
+	err := cGorm.viewableItems(viewerID).
+		Select("images.*, galleries.title AS gallery_title, galleries.visibility AS gallery_visibility, "+
+			"galleries.public_id AS gallery_public_id, collection_images.created_at AS added_at").
+		Where("collection_images.collection_id = ?", collectionID).
+		Order("collection_images.created_at DESC, images.id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]CollectionItem, len(rows))
+	for i, row := range rows {
+		items[i] = CollectionItem{
+			Image: row.Image,
+			Gallery: Gallery{
+				Model:      gorm.Model{ID: row.GalleryID},
+				Title:      row.GalleryTitle,
+				Visibility: row.GalleryVisibility,
+				PublicID:   row.GalleryPublicID,
+			},
+			AddedAt: row.AddedAt,
+		}
+	}
+	return items, nil
+}
+
+// viewableItems joins the images in collections with their galleries,
+// keeping those viewerID may see.
+func (cGorm *collectionGorm) viewableItems(viewerID uint) *gorm.DB {
+	db := cGorm.db.Table("collection_images").
+		Joins("JOIN images ON images.id = collection_images.image_id").
+		Joins("JOIN galleries ON galleries.id = images.gallery_id")
+	return whereViewableBy(db, viewerID)
+}
+
+func (cGorm *collectionGorm) AddImage(collectionID, imageID uint) error {
+	item := CollectionImage{CollectionID: collectionID, ImageID: imageID}
+	return cGorm.db.Where(item).FirstOrCreate(&item).Error
+}
+
+func (cGorm *collectionGorm) RemoveImage(collectionID, imageID uint) error {
+	return cGorm.db.Where("collection_id = ? AND image_id = ?", collectionID, imageID).Delete(&CollectionImage{}).Error
+}
+
+func (cGorm *collectionGorm) Containing(userID uint, imageIDs ...uint) (map[uint][]uint, error) {
+	result := make(map[uint][]uint, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+	var rows []CollectionImage
+	err := cGorm.db.Table("collection_images").
+		Select("collection_images.*").
+		Joins("JOIN collections ON collections.id = collection_images.collection_id AND collections.deleted_at IS NULL").
+		Where("collections.user_id = ? AND collection_images.image_id IN (?)", userID, imageIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ImageID] = append(result[row.ImageID], row.CollectionID)
+	}
+	return result, nil
+}
+
+func (cGorm *collectionGorm) FavoriteCounts(imageIDs ...uint) (map[uint]int, error) {
+	result := make(map[uint]int, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		ImageID uint
+		Count   int
+	}
+	err := cGorm.db.Table("collection_images").
+		Select("collection_images.image_id, count(DISTINCT collections.user_id) AS count").
+		Joins("JOIN collections ON collections.id = collection_images.collection_id AND collections.deleted_at IS NULL").
+		Where("collection_images.image_id IN (?)", imageIDs).
+		Group("collection_images.image_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ImageID] = row.Count
+	}
+	return result, nil
+}
@@ -0,0 +1,276 @@
+package models
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxCommentLen is the number of characters in a comment
+const maxCommentLen = 2000
+
+// Comment is what a user wrote about an image. Comments hidden by the
+// gallery's owner are only shown to them. Notified is set once the
+// owner has been emailed about the comment.
+type Comment struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	ImageID   uint   `gorm:"not null;index"`
+	UserID    uint   `gorm:"not null;index"`
+	Body      string `gorm:"type:text;not null"`
+	Hidden    bool   `gorm:"not null;default:false"`
+	Notified  bool   `gorm:"not null;default:false;index"`
+	// AuthorName and GalleryTitle are filled in by the queries which
+	// list comments
+	AuthorName   string `gorm:"-"`
+	GalleryTitle string `gorm:"-"`
+}
+
+// CommentDigest holds the comments on the galleries of Owner which
+// they haven't been notified of yet, oldest first.
+type CommentDigest struct {
+	Owner    User
+	Comments []Comment
+}
+
+// IDs lists the IDs of the comments in the digest.
+func (digest *CommentDigest) IDs() []uint {
+	ids := make([]uint, len(digest.Comments))
+	for i, comment := range digest.Comments {
+		ids[i] = comment.ID
+	}
+	return ids
+}
+
+type CommentService interface {
+	CommentDB
+}
+
+func NewCommentService(db *gorm.DB) CommentService {
+	return &commentService{
+		CommentDB: &commentValidator{
+			CommentDB: &commentGorm{db},
+		},
+	}
+}
+
+type commentService struct {
+	CommentDB
+}
+
+var _ CommentDB = &commentValidator{}
+
+type commentValidator struct {
+	CommentDB
+}
+
+func (cValidator *commentValidator) Create(comment *Comment) error {
+	err := runCommentValFuncs(comment,
+		cValidator.requireGalleryID,
+		cValidator.requireImageID,
+		cValidator.requireUserID,
+		cValidator.normalizeBody,
+		cValidator.requireBody,
+		cValidator.bodyLength)
+	if err != nil {
+		return err
+	}
+	return cValidator.CommentDB.Create(comment)
+}
+
+func (cValidator *commentValidator) SetHidden(id uint, hidden bool) error {
+	var comment Comment
+	comment.ID = id
+	if err := runCommentValFuncs(&comment, cValidator.validateID); err != nil {
+		return err
+	}
+	return cValidator.CommentDB.SetHidden(comment.ID, hidden)
+}
+
+func (cValidator *commentValidator) Delete(id uint) error {
+	var comment Comment
+	comment.ID = id
+	if err := runCommentValFuncs(&comment, cValidator.validateID); err != nil {
+		return err
+	}
+	return cValidator.CommentDB.Delete(comment.ID)
+}
+
+type commentValFunc func(*Comment) error
+
+func runCommentValFuncs(comment *Comment, funcs ...commentValFunc) error {
+	for _, function := range funcs {
+		if err := function(comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) requireGalleryID(comment *Comment) error {
+	if comment.GalleryID <= 0 {
+		return ErrRequiredGalleryID
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) requireImageID(comment *Comment) error {
+	if comment.ImageID <= 0 {
+		return ErrRequiredImageID
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) requireUserID(comment *Comment) error {
+	if comment.UserID <= 0 {
+		return ErrRequiredUserID
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) normalizeBody(comment *Comment) error {
+	comment.Body = strings.TrimSpace(comment.Body)
+	return nil
+}
+
+func (cValidator *commentValidator) requireBody(comment *Comment) error {
+	if comment.Body == "" {
+		return ErrRequiredComment
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) bodyLength(comment *Comment) error {
+	if utf8.RuneCountInString(comment.Body) > maxCommentLen {
+		return ErrCommentTooLong
+	}
+	return nil
+}
+
+func (cValidator *commentValidator) validateID(comment *Comment) error {
+	if comment.ID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+type CommentDB interface {
+	Create(comment *Comment) error
+	SetHidden(id uint, hidden bool) error
+	Delete(id uint) error
+
+	ByID(id uint) (*Comment, error)
+	// ByImageIDs returns the comments on each image, oldest first,
+	// keyed by image ID. Hidden comments are left out unless
+	// includeHidden is set.
+	ByImageIDs(includeHidden bool, imageIDs ...uint) (map[uint][]Comment, error)
+
+	// Digests returns the comments each gallery owner hasn't been
+	// notified of, leaving out hidden comments and their own.
+	Digests() ([]CommentDigest, error)
+	MarkNotified(ids ...uint) error
+}
+
+var _ CommentDB = &commentGorm{}
+
+type commentGorm struct {
+	db *gorm.DB
+}
+
+func (cGorm *commentGorm) Create(comment *Comment) error {
+	return cGorm.db.Create(comment).Error
+}
+
+func (cGorm *commentGorm) SetHidden(id uint, hidden bool) error {
+	return cGorm.db.Model(&Comment{}).Where("id = ?", id).Update("hidden", hidden).Error
+}
+
+func (cGorm *commentGorm) Delete(id uint) error {
+	comment := Comment{Model: gorm.Model{ID: id}}
+	return cGorm.db.Delete(&comment).Error
+}
+
+func (cGorm *commentGorm) ByID(id uint) (*Comment, error) {
+	var comment Comment
+	err := first(cGorm.db.Where("id = ?", id), &comment)
+	return &comment, err
+}
+
+func (cGorm *commentGorm) ByImageIDs(includeHidden bool, imageIDs ...uint) (map[uint][]Comment, error) {
+	result := make(map[uint][]Comment, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+	db := cGorm.db.Table("comments").
+		Select("comments.*, users.name AS author_name").
+		Joins("LEFT JOIN users ON users.id = comments.user_id").
+		Where("comments.image_id IN (?) AND comments.deleted_at IS NULL", imageIDs)
+	if !includeHidden {
+		db = db.Where("NOT comments.hidden")
+	}
+	var rows []struct {
+		Comment
+		AuthorName string
+	}
+	if err := db.Order("comments.created_at, comments.id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.Comment.AuthorName = row.AuthorName
+		result[row.ImageID] = append(result[row.ImageID], row.Comment)
+	}
+	return result, nil
+}
+
+func (cGorm *commentGorm) Digests() ([]CommentDigest, error) {
+	var rows []struct {
+		Comment
+		AuthorName   string
+		GalleryTitle string
+		OwnerID      uint
+	}
+	err := cGorm.db.Table("comments").
+		Select("comments.*, users.name AS author_name, galleries.title AS gallery_title, galleries.user_id AS owner_id").
+		Joins("JOIN galleries ON galleries.id = comments.gallery_id AND galleries.deleted_at IS NULL").
+		Joins("LEFT JOIN users ON users.id = comments.user_id").
+		Where("comments.deleted_at IS NULL AND NOT comments.notified AND NOT comments.hidden").
+		Where("comments.user_id <> galleries.user_id").
+		Order("galleries.user_id, comments.created_at, comments.id").
+		Scan(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	var ownerIDs []uint
+	digests := make(map[uint]*CommentDigest)
+	for _, row := range rows {
+		digest, ok := digests[row.OwnerID]
+		if !ok {
+			digest = &CommentDigest{}
+			digests[row.OwnerID] = digest
+			ownerIDs = append(ownerIDs, row.OwnerID)
+		}
+		row.Comment.AuthorName = row.AuthorName
+		row.Comment.GalleryTitle = row.GalleryTitle
+		digest.Comments = append(digest.Comments, row.Comment)
+	}
+	var owners []User
+	if err := cGorm.db.Where("id IN (?)", ownerIDs).Find(&owners).Error; err != nil {
+		return nil, err
+	}
+	result := make([]CommentDigest, 0, len(owners))
+	for _, owner := range owners {
+		digest := digests[owner.ID]
+		digest.Owner = owner
+		result = append(result, *digest)
+	}
+	return result, nil
+}
+
+func (cGorm *commentGorm) MarkNotified(ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return cGorm.db.Model(&Comment{}).Where("id IN (?)", ids).UpdateColumn("notified", true).Error
+}
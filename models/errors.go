@@ -1,23 +1,69 @@
 package models
 
-import "strings"
+import (
+	"strconv"
+	"strings"
+)
 
 var (
-	ErrNotFound             privateError = "models: resource not found"
-	ErrInvalidID            privateError = "models: ID provided is not valid"
-	ErrRequiredUserID       privateError = "models: ID is required"
-	ErrInvalidEmail         modelError   = "models: Email address is not valid"
-	ErrRequiredEmail        modelError   = "models: Email address is required"
-	ErrTakenEmail           modelError   = "models: Email address is already taken"
-	ErrInvalidPassword      modelError   = "models: Password is not valid"
-	ErrRequiredPassword     modelError   = "models: Password is required"
-	ErrIncorrectPassword    modelError   = "models: Incorrect password provided"
-	ErrInvalidRemeber       privateError = "models: Remember token must be an adequate length"
-	ErrRequiredRememberHash privateError = "models: Remember hash is required"
-	ErrRequiredTitle        modelError   = "models: Title is required"
-	ErrInvalidResetToken    modelError   = "models: Token provided is not valid"
-	ErrExpiredResetToken    modelError   = "models: Token provided has expired"
-	ErrRequiredServiceName  privateError = "models: Service name is required"
+	ErrNotFound                privateError = "models: resource not found"
+	ErrInvalidID               privateError = "models: ID provided is not valid"
+	ErrRequiredUserID          privateError = "models: ID is required"
+	ErrInvalidEmail            modelError   = "models: Email address is not valid"
+	ErrRequiredEmail           modelError   = "models: Email address is required"
+	ErrTakenEmail              modelError   = "models: Email address is already taken"
+	ErrInvalidPassword         modelError   = "models: Password is not valid"
+	ErrRequiredPassword        modelError   = "models: Password is required"
+	ErrIncorrectPassword       modelError   = "models: Incorrect password provided"
+	ErrInvalidRemeber          privateError = "models: Remember token must be an adequate length"
+	ErrRequiredRememberHash    privateError = "models: Remember hash is required"
+	ErrRequiredTitle           modelError   = "models: Title is required"
+	ErrDescriptionTooLong      modelError   = "models: Description must be at most 5000 characters long"
+	ErrInvalidResetToken       modelError   = "models: Token provided is not valid"
+	ErrExpiredResetToken       modelError   = "models: Token provided has expired"
+	ErrRequiredServiceName     privateError = "models: Service name is required"
+	ErrRequiredImageService    privateError = "models: Image service must be configured before the gallery service"
+	ErrRequiredGalleryID       privateError = "models: Gallery ID is required"
+	ErrRequiredFilename        modelError   = "models: Filename is required"
+	ErrRequiredChecksum        privateError = "models: Image checksum is required"
+	ErrInvalidFilename         privateError = "models: Filename is not valid"
+	ErrImageType               modelError   = "models: Only JPEG and PNG images are supported"
+	ErrImageTooLarge           modelError   = "models: Image exceeds the maximum file size"
+	ErrImageDimensions         modelError   = "models: Image exceeds the maximum number of pixels"
+	ErrImageCorrupt            modelError   = "models: Image could not be read and may be corrupt"
+	ErrInvalidImageSort        modelError   = "models: Image order is not valid"
+	ErrGalleryPasswordTooShort modelError   = "models: Gallery password must be at least 6 characters long"
+	ErrTooManyAttempts         modelError   = "models: Too many incorrect passwords, please try again later"
+	ErrInvalidExpiry           modelError   = "models: Expiry date must be in the future"
+	ErrShareLinkExpired        modelError   = "models: This link has expired"
+	ErrInvalidVisibility       modelError   = "models: Visibility must be private, unlisted or public"
+	ErrInvalidImageOrder       modelError   = "models: Image order must list every image in the gallery exactly once"
+	ErrArchiveCorrupt          modelError   = "models: Archive could not be read and may be corrupt"
+	ErrArchiveTooManyFiles     modelError   = "models: Archive contains too many files"
+	ErrArchiveTooLarge         modelError   = "models: Archive exceeds the maximum uncompressed size"
+	ErrArchivePath             modelError   = "models: File is stored outside of the archive"
+	ErrCaptionTooLong          modelError   = "models: Captions must be at most 500 characters long"
+	ErrSearchQueryTooLong      modelError   = "models: Search must be at most 200 characters long"
+	ErrInvalidSort             modelError   = "models: Sort order is not valid"
+	ErrInvalidCursor           modelError   = "models: Page is not valid, please start over from the first page"
+	ErrRequiredImageID         privateError = "models: Image ID is required"
+	ErrRequiredComment         modelError   = "models: Comment can't be empty"
+	ErrCommentTooLong          modelError   = "models: Comments must be at most 2000 characters long"
+	ErrCommentsDisabled        modelError   = "models: Comments are turned off for this gallery"
+	ErrRequiredCollectionName  modelError   = "models: Collection name is required"
+	ErrCollectionNameTooLong   modelError   = "models: Collection names must be at most 64 characters long"
+	ErrFollowSelf              modelError   = "models: You can't follow yourself"
+	ErrInvalidEvent            privateError = "models: Event kind is not valid"
+	ErrRequiredVisitor         privateError = "models: Visitor is required"
+	ErrInvalidPeriod           modelError   = "models: Analytics are available for at most a year at a time"
+	ErrInvalidTag              modelError   = "models: Tags may only contain letters, numbers, dashes and underscores"
+	ErrTagTooLong              modelError   = "models: Tags must be at most 32 characters long"
+	ErrTooManyTags             modelError   = "models: At most 20 tags are allowed"
+	ErrInvalidRole             modelError   = "models: Role must be viewer, contributor or editor"
+	ErrAlreadyMember           modelError   = "models: That email address has already been invited"
+	ErrInviteOwner             modelError   = "models: You can't invite yourself to your own gallery"
+	ErrInvalidInvitation       modelError   = "models: Invitation is not valid or has already been accepted"
+	ErrInvitationEmail         modelError   = "models: Invitation was sent to a different email address"
 )
 
 type modelError string
@@ -38,3 +84,47 @@ type privateError string
 func (e privateError) Error() string {
 	return string(e)
 }
+
+// FileError is returned for a single file of a batch upload.
+type FileError struct {
+	Filename string
+	Err      error
+}
+
+// FileErrors collects the errors of a batch upload so that every
+// rejected file can be reported back to the user at once.
+type FileErrors []FileError
+
+func (e FileErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fileErr := range e {
+		messages[i] = fileErr.Filename + ": " + fileErr.Err.Error()
+	}
+	return "models: " + strings.Join(messages, "; ")
+}
+
+func (e FileErrors) Public() string {
+	if len(e) == 1 {
+		return "1 file was rejected"
+	}
+	return strconv.Itoa(len(e)) + " files were rejected"
+}
+
+// Details lists each rejected file along with the reason it was
+// rejected.
+func (e FileErrors) Details() []string {
+	details := make([]string, len(e))
+	for i, fileErr := range e {
+		details[i] = fileErr.Filename + ": " + fileErr.Reason()
+	}
+	return details
+}
+
+// Reason explains why the file was rejected. Reasons that are not
+// public are replaced by a generic one.
+func (fileErr FileError) Reason() string {
+	if publicErr, ok := fileErr.Err.(modelError); ok {
+		return publicErr.Public()
+	}
+	return "Something went wrong while saving this file"
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	ActivityGallery = "gallery"
+	ActivityUpload  = "upload"
+
+	// activityPreviewSize is the number of images shown with each
+	// activity
+	activityPreviewSize = 4
+)
+
+// Follow subscribes FollowerID to the activity of FolloweeID.
+type Follow struct {
+	FollowerID uint `gorm:"primary_key;auto_increment:false"`
+	FolloweeID uint `gorm:"primary_key;auto_increment:false;index"`
+	CreatedAt  time.Time
+}
+
+// Activity is something a followed user did in one of their public
+// galleries: creating it, or uploading ImageCount images to it on the
+// same day. At is when it happened, or when the last of the images
+// was uploaded. Images are a preview of the newest images.
+type Activity struct {
+	Kind       string
+	GalleryID  uint
+	UserID     uint
+	At         time.Time
+	ImageCount int
+	Gallery    Gallery `gorm:"-"`
+	User       User    `gorm:"-"`
+	Images     []Image `gorm:"-"`
+}
+
+// FollowCounts are the number of users following a user and the
+// number of users they follow.
+type FollowCounts struct {
+	Followers int
+	Following int
+}
+
+type FollowService interface {
+	FollowDB
+}
+
+func NewFollowService(db *gorm.DB) FollowService {
+	return &followService{
+		FollowDB: &followValidator{
+			FollowDB: &followGorm{db},
+		},
+	}
+}
+
+type followService struct {
+	FollowDB
+}
+
+var _ FollowDB = &followValidator{}
+
+type followValidator struct {
+	FollowDB
+}
+
+func (fValidator *followValidator) Follow(followerID, followeeID uint) error {
+	if followerID <= 0 || followeeID <= 0 {
+		return ErrInvalidID
+	}
+	if followerID == followeeID {
+		return ErrFollowSelf
+	}
+	return fValidator.FollowDB.Follow(followerID, followeeID)
+}
+
+func (fValidator *followValidator) Unfollow(followerID, followeeID uint) error {
+	if followerID <= 0 || followeeID <= 0 {
+		return ErrInvalidID
+	}
+	return fValidator.FollowDB.Unfollow(followerID, followeeID)
+}
+
+// Feed lists activity newest first, the only order it has.
+func (fValidator *followValidator) Feed(userID uint, query PageQuery) ([]Activity, *Page, error) {
+	if err := normalizePageQuery(&query, SortCreated, []string{SortCreated}); err != nil {
+		return nil, nil, err
+	}
+	query.Order = OrderDesc
+	return fValidator.FollowDB.Feed(userID, query)
+}
+
+type FollowDB interface {
+	// Follow makes followerID follow followeeID, unless they do
+	// already.
+	Follow(followerID, followeeID uint) error
+	Unfollow(followerID, followeeID uint) error
+	IsFollowing(followerID, followeeID uint) (bool, error)
+	Counts(userID uint) (*FollowCounts, error)
+
+	// Feed returns a page of the activity of the users userID follows
+	// in their public galleries. It is gathered when read rather than
+	// stored for each follower.
+	Feed(userID uint, query PageQuery) ([]Activity, *Page, error)
+}
+
+var _ FollowDB = &followGorm{}
+
+type followGorm struct {
+	db *gorm.DB
+}
+
+func (fGorm *followGorm) Follow(followerID, followeeID uint) error {
+	follow := Follow{FollowerID: followerID, FolloweeID: followeeID}
+	return fGorm.db.Where(follow).FirstOrCreate(&follow).Error
+}
+
+func (fGorm *followGorm) Unfollow(followerID, followeeID uint) error {
+	return fGorm.db.Where("follower_id = ? AND followee_id = ?", followerID, followeeID).Delete(&Follow{}).Error
+}
+
+func (fGorm *followGorm) IsFollowing(followerID, followeeID uint) (bool, error) {
+	var count int
+	err := fGorm.db.Model(&Follow{}).
+		Where("follower_id = ? AND followee_id = ?", followerID, followeeID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (fGorm *followGorm) Counts(userID uint) (*FollowCounts, error) {
+	var counts FollowCounts
+	err := fGorm.db.Model(&Follow{}).Where("followee_id = ?", userID).Count(&counts.Followers).Error
+	if err != nil {
+		return nil, err
+	}
+	err = fGorm.db.Model(&Follow{}).Where("follower_id = ?", userID).Count(&counts.Following).Error
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
+}
+
+// activitySQL selects the activity of a subquery of users: their
+// public galleries, and the images they uploaded to public galleries
+// grouped by gallery and day. Its parameters are VisibilityPublic and
+// the subquery, twice. Activity is ordered by (at, kind, gallery_id).
+const activitySQL = `SELECT * FROM (
+	SELECT '` + ActivityGallery + `' AS kind, galleries.id AS gallery_id, galleries.user_id,
+		galleries.created_at AS at, 0 AS image_count
+	FROM galleries
+	WHERE ` + publicGalleryCondition + ` AND galleries.user_id IN (?)
+	UNION ALL
+	SELECT '` + ActivityUpload + `' AS kind, images.gallery_id, images.user_id,
+		max(images.created_at) AS at, count(*) AS image_count
+	FROM images JOIN galleries ON galleries.id = images.gallery_id
+	WHERE ` + publicGalleryCondition + ` AND images.user_id IN (?)
+	GROUP BY images.gallery_id, images.user_id, date_trunc('day', images.created_at)
+) AS activities`
+
+func (fGorm *followGorm) Feed(userID uint, query PageQuery) ([]Activity, *Page, error) {
+	followees := fGorm.db.New().Table("follows").Select("followee_id").
+		Where("follower_id = ?", userID).QueryExpr()
+	sql := activitySQL
+	args := []interface{}{VisibilityPublic, followees, VisibilityPublic, followees}
+
+	// Pages before a cursor are read backwards from it and reversed.
+	reverse := query.Before != ""
+	order, comparison := "DESC", "<"
+	if reverse {
+		order, comparison = "ASC", ">"
+	}
+	if position := query.After + query.Before; position != "" {
+		c, err := decodeCursor(position)
+		if err != nil {
+			return nil, nil, err
+		}
+		at, kind, err := activitySortValue(c.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		sql += " WHERE (at, kind, gallery_id) " + comparison + " (?, ?, ?)"
+		args = append(args, at, kind, c.ID)
+	}
+	sql += " ORDER BY at " + order + ", kind " + order + ", gallery_id " + order + " LIMIT ?"
+	args = append(args, query.Limit+1)
+
+	var activities []Activity
+	if err := fGorm.db.Raw(sql, args...).Scan(&activities).Error; err != nil {
+		return nil, nil, err
+	}
+	more := len(activities) > query.Limit
+	if more {
+		activities = activities[:query.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(activities)-1; i < j; i, j = i+1, j-1 {
+			activities[i], activities[j] = activities[j], activities[i]
+		}
+	}
+
+	page := &Page{Sort: query.Sort, Order: query.Order}
+	if len(activities) == 0 {
+		return activities, page, nil
+	}
+	first, last := activities[0], activities[len(activities)-1]
+	if more || query.Before != "" {
+		page.Next = encodeCursor(activitySortText(&last), last.GalleryID)
+	}
+	if (more && reverse) || query.After != "" {
+		page.Prev = encodeCursor(activitySortText(&first), first.GalleryID)
+	}
+	if err := fGorm.loadActivities(activities); err != nil {
+		return nil, nil, err
+	}
+	return activities, page, nil
+}
+
+// loadActivities fills in the gallery, user and preview images of each
+// activity.
+func (fGorm *followGorm) loadActivities(activities []Activity) error {
+	var galleryIDs, userIDs []uint
+	for _, activity := range activities {
+		galleryIDs = append(galleryIDs, activity.GalleryID)
+		userIDs = append(userIDs, activity.UserID)
+	}
+	var galleries []Gallery
+	if err := fGorm.db.Where("id IN (?)", galleryIDs).Find(&galleries).Error; err != nil {
+		return err
+	}
+	var users []User
+	if err := fGorm.db.Where("id IN (?)", userIDs).Find(&users).Error; err != nil {
+		return err
+	}
+	galleriesByID := make(map[uint]Gallery, len(galleries))
+	for _, gallery := range galleries {
+		galleriesByID[gallery.ID] = gallery
+	}
+	usersByID := make(map[uint]User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	for i := range activities {
+		activity := &activities[i]
+		activity.Gallery = galleriesByID[activity.GalleryID]
+		activity.User = usersByID[activity.UserID]
+		db := fGorm.db.Where("gallery_id = ?", activity.GalleryID)
+		if activity.Kind == ActivityUpload {
+			db = db.Where("user_id = ? AND created_at <= ?", activity.UserID, activity.At).
+				Where("date_trunc('day', created_at) = date_trunc('day', CAST(? AS timestamptz))", activity.At).
+				Order("created_at DESC, id DESC")
+		} else {
+			db = db.Order("position, created_at, id")
+		}
+		if err := db.Limit(activityPreviewSize).Find(&activity.Images).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activitySortText is the sort key of an activity as stored in cursors,
+// which hold the gallery ID as the cursor's ID.
+func activitySortText(activity *Activity) string {
+	return activity.Kind + " " + activity.At.UTC().Format(time.RFC3339Nano)
+}
+
+// activitySortValue parses the sort key of a cursor.
+func activitySortValue(text string) (time.Time, string, error) {
+	parts := strings.SplitN(text, " ", 2)
+	if len(parts) != 2 || (parts[0] != ActivityGallery && parts[0] != ActivityUpload) {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	at, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return at, parts[0], nil
+}
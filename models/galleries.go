@@ -1,15 +1,196 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"encoding/base64"
+	"go-web-dev/markdown"
+	"go-web-dev/rand"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
-// Gallery is our image container that visitors view
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// ImageSortUploaded shows images in the order they were uploaded
+	ImageSortUploaded = "uploaded"
+	// ImageSortCaptured shows images in the order they were taken,
+	// using the upload time for images without a capture time
+	ImageSortCaptured = "captured"
+	// ImageSortManual shows images in the order chosen by the owner
+	ImageSortManual = "manual"
+
+	// VisibilityPrivate galleries are only visible to their owner
+	VisibilityPrivate = "private"
+	// VisibilityUnlisted galleries are visible to anyone who has been
+	// given their link, which uses the PublicID
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPublic galleries are visible to everyone
+	VisibilityPublic = "public"
+
+	publicIDBytes = 16
+	// maxDescriptionLen is the number of characters a description may
+	// have, counting its Markdown
+	maxDescriptionLen = 5000
+)
+
+// Gallery is our image container that visitors view. Other users can
+// be given a role in the gallery as its Members.
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not null;index"`
-	Title  string  `gorm:"not null"`
-	Images []Image `gorm:"-"`
+	UserID uint   `gorm:"not null;index"`
+	Title  string `gorm:"not null"`
+	// Description is stored as the Markdown it was written in
+	Description string `gorm:"type:text"`
+	// Visibility decides who may view the gallery. PublicID is the
+	// unguessable identifier used in the links of unlisted galleries.
+	Visibility string `gorm:"not null;default:'private'"`
+	PublicID   string `gorm:"unique_index"`
+	Password   string `gorm:"-"`
+	// PasswordHash is set for galleries which ask visitors other than
+	// the owner for their password
+	PasswordHash string
+	// KeepLocation keeps the location of uploaded photos, which is
+	// stripped otherwise. Photos which have to be rotated upright lose
+	// it either way, as re-encoding them drops all of their metadata.
+	KeepLocation bool   `gorm:"not null;default:false"`
+	ImageSort    string `gorm:"not null;default:'uploaded'"`
+	// CoverImageID is the image representing the gallery in listings.
+	// Its first image does when no cover was chosen.
+	CoverImageID uint
+	Cover        *Image     `gorm:"-"`
+	Images       []Image    `gorm:"-"`
+	Stats        ImageStats `gorm:"-"`
+	// ShareLink is the link the gallery is being viewed through, if any
+	ShareLink  *ShareLink  `gorm:"-"`
+	ShareLinks []ShareLink `gorm:"-"`
+	// Role is the current user's role in the gallery, if any
+	Role    string          `gorm:"-"`
+	Members []GalleryMember `gorm:"-"`
+	Tags    []Tag           `gorm:"-"`
+	// OwnerName is the name of the user owning the gallery, filled in
+	// by paged listings
+	OwnerName string `gorm:"-"`
+	// CommentsDisabled stops new comments on the gallery's images
+	CommentsDisabled bool `gorm:"not null;default:false"`
+}
+
+// OwnedBy reports whether user, who may be nil, owns the gallery.
+func (gallery *Gallery) OwnedBy(user *User) bool {
+	return user != nil && user.ID == gallery.UserID
+}
+
+// VisibleTo reports whether user, who may be nil, can view the gallery.
+// byPublicID is set when the gallery was reached through its PublicID,
+// which is what unlisted galleries require.
+func (gallery *Gallery) VisibleTo(user *User, byPublicID bool) bool {
+	if gallery.OwnedBy(user) || gallery.Can(PermissionView) {
+		return true
+	}
+	switch gallery.Visibility {
+	case VisibilityPublic:
+		return true
+	case VisibilityUnlisted:
+		return byPublicID
+	default:
+		return false
+	}
+}
+
+// Listed reports whether anyone may find the gallery in listings and
+// feeds, as selected by wherePublic.
+func (gallery *Gallery) Listed() bool {
+	return gallery.Visibility == VisibilityPublic && !gallery.HasPassword()
+}
+
+// DescriptionHTML renders the description, which is written in
+// Markdown.
+func (gallery *Gallery) DescriptionHTML() template.HTML {
+	return markdown.Render(gallery.Description)
+}
+
+// Can reports whether the current user's Role allows permission.
+func (gallery *Gallery) Can(permission Permission) bool {
+	return RoleAllows(gallery.Role, permission)
+}
+
+// CanUpload reports whether the current user may add images.
+func (gallery *Gallery) CanUpload() bool {
+	return gallery.Can(PermissionUpload)
+}
+
+// CanEdit reports whether the current user may rename the gallery and
+// rearrange or delete its images.
+func (gallery *Gallery) CanEdit() bool {
+	return gallery.Can(PermissionEdit)
+}
+
+// CanManage reports whether the current user may change who can see
+// the gallery, or delete it.
+func (gallery *Gallery) CanManage() bool {
+	return gallery.Can(PermissionManage)
+}
+
+// Path is the link to share for the gallery. Unlisted galleries can
+// only be reached through their PublicID, and galleries viewed through
+// a share link keep using it.
+func (gallery *Gallery) Path() string {
+	if gallery.ShareLink != nil {
+		return gallery.ShareLink.Path()
+	}
+	if gallery.Visibility == VisibilityUnlisted {
+		return "/g/" + gallery.PublicID
+	}
+	return "/galleries/" + strconv.Itoa(int(gallery.ID))
 }
 
+// CanDownload reports whether the viewer may download the gallery as
+// an archive, which share links have to allow.
+func (gallery *Gallery) CanDownload() bool {
+	return gallery.ShareLink == nil || gallery.ShareLink.AllowDownload
+}
+
+// CoverImage returns the image representing the gallery, or nil when
+// the gallery is empty.
+func (gallery *Gallery) CoverImage() *Image {
+	if gallery.Cover != nil {
+		return gallery.Cover
+	}
+	for i := range gallery.Images {
+		if gallery.Images[i].ID == gallery.CoverImageID {
+			return &gallery.Images[i]
+		}
+	}
+	if len(gallery.Images) > 0 {
+		return &gallery.Images[0]
+	}
+	return nil
+}
+
+// SortImages orders the gallery's images according to its ImageSort.
+// Images are expected in their stored order, which is kept for manual
+// sorting.
+func (gallery *Gallery) SortImages() {
+	images := gallery.Images
+	switch gallery.ImageSort {
+	case ImageSortCaptured:
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].capturedAt().Before(images[j].capturedAt())
+		})
+	case ImageSortManual:
+	default:
+		sort.SliceStable(images, func(i, j int) bool {
+			return images[i].CreatedAt.Before(images[j].CreatedAt)
+		})
+	}
+}
+
+// SplitImages deals the images out into n columns, so that reading the
+// columns row by row keeps the gallery's order.
 func (gallery *Gallery) SplitImages(n int) [][]Image {
 	result := make([][]Image, n)
 	for i := 0; i < n; i++ {
@@ -23,31 +204,92 @@ func (gallery *Gallery) SplitImages(n int) [][]Image {
 }
 
 type GalleryService interface {
+	// Unlock checks the password a visitor entered for the gallery.
+	// Failed attempts are throttled per gallery.
+	Unlock(gallery *Gallery, password string) error
+	// AccessToken returns a token proving the gallery was unlocked,
+	// which is valid until expiresAt or the password changes.
+	AccessToken(gallery *Gallery, expiresAt time.Time) string
+	ValidAccessToken(gallery *Gallery, token string) bool
+	// Purge permanently deletes a gallery from the trash along with
+	// its images.
+	Purge(id uint) error
+	// PurgeTrash purges the galleries moved to the trash before
+	// deletedBefore, returning how many were purged.
+	PurgeTrash(deletedBefore time.Time) (int, error)
 	GalleryDB
 }
 
-func NewGalleryService(db *gorm.DB) GalleryService {
+// NewGalleryService returns a GalleryService which removes the images
+// of a gallery through imageService when the gallery is purged from
+// the trash.
+func NewGalleryService(db *gorm.DB, imageService ImageService, pepper string, hmacSecretKey string) GalleryService {
 	return &galleryService{
 		GalleryDB: &galleryValidator{
 			GalleryDB: &galleryGorm{db},
+			pepper:    pepper,
 		},
+		imgService: imageService,
+		pepper:     pepper,
+		hmacKey:    hmacSecretKey,
+		attempts:   newAttemptLimiter(maxPasswordAttempts, passwordAttemptWindow),
 	}
 }
 
 type galleryService struct {
 	GalleryDB
+	imgService ImageService
+	pepper     string
+	hmacKey    string
+	attempts   *attemptLimiter
+}
+
+// Purge removes every image of the gallery, including their files,
+// before deleting the gallery itself so that no files outlive it.
+func (gService *galleryService) Purge(id uint) error {
+	images, err := gService.imgService.ByGalleryID(id)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		if err := gService.imgService.Delete(&images[i]); err != nil {
+			return err
+		}
+	}
+	return gService.GalleryDB.Purge(id)
+}
+
+func (gService *galleryService) PurgeTrash(deletedBefore time.Time) (int, error) {
+	galleries, err := gService.GalleryDB.TrashedBefore(deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	for i, gallery := range galleries {
+		if err := gService.Purge(gallery.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(galleries), nil
 }
 
 var _ GalleryDB = &galleryGorm{}
 
 type galleryValidator struct {
 	GalleryDB
+	pepper string
 }
 
 func (gValidator *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gValidator.requireUserID,
-		gValidator.requireTitle)
+		gValidator.requireTitle,
+		gValidator.normalizeDescription,
+		gValidator.descriptionLength,
+		gValidator.validImageSort,
+		gValidator.validVisibility,
+		gValidator.setPublicIDIfUnset,
+		gValidator.passwordLength,
+		gValidator.hashPassword)
 	if err != nil {
 		return err
 	}
@@ -57,7 +299,14 @@ func (gValidator *galleryValidator) Create(gallery *Gallery) error {
 func (gValidator *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gValidator.requireUserID,
-		gValidator.requireTitle)
+		gValidator.requireTitle,
+		gValidator.normalizeDescription,
+		gValidator.descriptionLength,
+		gValidator.validImageSort,
+		gValidator.validVisibility,
+		gValidator.setPublicIDIfUnset,
+		gValidator.passwordLength,
+		gValidator.hashPassword)
 	if err != nil {
 		return err
 	}
@@ -75,6 +324,50 @@ func (gValidator *galleryValidator) Delete(id uint) error {
 	return gValidator.GalleryDB.Delete(gallery.ID)
 }
 
+func (gValidator *galleryValidator) Restore(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	if err := runGalleryValFuncs(&gallery, gValidator.validateID); err != nil {
+		return err
+	}
+	return gValidator.GalleryDB.Restore(gallery.ID)
+}
+
+func (gValidator *galleryValidator) Purge(id uint) error {
+	var gallery Gallery
+	gallery.ID = id
+	if err := runGalleryValFuncs(&gallery, gValidator.validateID); err != nil {
+		return err
+	}
+	return gValidator.GalleryDB.Purge(gallery.ID)
+}
+
+func (gValidator *galleryValidator) PageForUser(userID uint, query PageQuery) ([]Gallery, *Page, error) {
+	if userID <= 0 {
+		return nil, nil, ErrRequiredUserID
+	}
+	if err := normalizePageQuery(&query, SortUpdated, GallerySorts); err != nil {
+		return nil, nil, err
+	}
+	return gValidator.GalleryDB.PageForUser(userID, query)
+}
+
+// PagePublic lists galleries in the order they were created only,
+// keeping pages of the listing the same for every visitor.
+func (gValidator *galleryValidator) PagePublic(filter PublicFilter, query PageQuery) ([]Gallery, *Page, error) {
+	if err := normalizePageQuery(&query, SortCreated, []string{SortCreated}); err != nil {
+		return nil, nil, err
+	}
+	return gValidator.GalleryDB.PagePublic(filter, query)
+}
+
+func (gValidator *galleryValidator) ByPublicID(publicID string) (*Gallery, error) {
+	if publicID == "" {
+		return nil, ErrNotFound
+	}
+	return gValidator.GalleryDB.ByPublicID(publicID)
+}
+
 type galleryValFunc func(*Gallery) error
 
 func runGalleryValFuncs(gallery *Gallery, funcs ...galleryValFunc) error {
@@ -100,6 +393,78 @@ func (gValidator *galleryValidator) requireTitle(gallery *Gallery) error {
 	return nil
 }
 
+func (gValidator *galleryValidator) normalizeDescription(gallery *Gallery) error {
+	gallery.Description = strings.TrimSpace(gallery.Description)
+	return nil
+}
+
+func (gValidator *galleryValidator) descriptionLength(gallery *Gallery) error {
+	if utf8.RuneCountInString(gallery.Description) > maxDescriptionLen {
+		return ErrDescriptionTooLong
+	}
+	return nil
+}
+
+func (gValidator *galleryValidator) validImageSort(gallery *Gallery) error {
+	switch gallery.ImageSort {
+	case "":
+		gallery.ImageSort = ImageSortUploaded
+	case ImageSortUploaded, ImageSortCaptured, ImageSortManual:
+	default:
+		return ErrInvalidImageSort
+	}
+	return nil
+}
+
+func (gValidator *galleryValidator) validVisibility(gallery *Gallery) error {
+	switch gallery.Visibility {
+	case "":
+		gallery.Visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+	default:
+		return ErrInvalidVisibility
+	}
+	return nil
+}
+
+// setPublicIDIfUnset also covers galleries created before public IDs
+// existed, which receive one the next time they are saved.
+func (gValidator *galleryValidator) setPublicIDIfUnset(gallery *Gallery) error {
+	if gallery.PublicID != "" {
+		return nil
+	}
+	bytes, err := rand.Bytes(publicIDBytes)
+	if err != nil {
+		return err
+	}
+	gallery.PublicID = base64.RawURLEncoding.EncodeToString(bytes)
+	return nil
+}
+
+func (gValidator *galleryValidator) passwordLength(gallery *Gallery) error {
+	if gallery.Password == "" {
+		return nil
+	}
+	if len(gallery.Password) < minGalleryPasswordLen {
+		return ErrGalleryPasswordTooShort
+	}
+	return nil
+}
+
+func (gValidator *galleryValidator) hashPassword(gallery *Gallery) error {
+	if gallery.Password == "" {
+		return nil
+	}
+	pwBytes := []byte(gallery.Password + gValidator.pepper)
+	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	gallery.PasswordHash = string(hashedBytes)
+	gallery.Password = ""
+	return nil
+}
+
 func (gValidator *galleryValidator) validateID(gallery *Gallery) error {
 	if gallery.ID <= 0 {
 		return ErrInvalidID
@@ -110,10 +475,46 @@ func (gValidator *galleryValidator) validateID(gallery *Gallery) error {
 type GalleryDB interface {
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
+	// Delete moves the gallery to the trash, hiding it from every
+	// other method until it is restored.
 	Delete(id uint) error
+	// Restore takes the gallery out of the trash.
+	Restore(id uint) error
+	// Purge removes a gallery from the trash for good, along with its
+	// tags, members and share links but not its images.
+	Purge(id uint) error
 
 	ByID(id uint) (*Gallery, error)
+	ByPublicID(publicID string) (*Gallery, error)
 	ByUserID(userID uint) ([]Gallery, error)
+	// ByMemberID returns the galleries userID has accepted an
+	// invitation to.
+	ByMemberID(userID uint) ([]Gallery, error)
+	// ByTagID returns the galleries carrying the tag which viewerID
+	// may see listed, most recently updated first.
+	ByTagID(tagID uint, viewerID uint) ([]Gallery, error)
+	// PageForUser returns a page of the galleries userID owns or has
+	// accepted an invitation to, sorted by one of GallerySorts.
+	PageForUser(userID uint, query PageQuery) ([]Gallery, *Page, error)
+	// PagePublic returns a page of the public galleries without a
+	// password matching filter, most recently created first.
+	PagePublic(filter PublicFilter, query PageQuery) ([]Gallery, *Page, error)
+
+	// TrashedByID returns a gallery which is in the trash.
+	TrashedByID(id uint) (*Gallery, error)
+	// TrashedByUserID returns the galleries userID moved to the trash,
+	// most recently deleted first.
+	TrashedByUserID(userID uint) ([]Gallery, error)
+	// TrashedBefore returns the galleries moved to the trash before
+	// deletedBefore.
+	TrashedBefore(deletedBefore time.Time) ([]Gallery, error)
+}
+
+// PublicFilter narrows down the public galleries listed by PagePublic.
+// Zero fields don't filter.
+type PublicFilter struct {
+	UserID uint
+	TagID  uint
 }
 
 var _ GalleryDB = &galleryGorm{}
@@ -123,11 +524,17 @@ type galleryGorm struct {
 }
 
 func (gGorm *galleryGorm) Create(gallery *Gallery) error {
-	return gGorm.db.Create(gallery).Error
+	if err := gGorm.db.Create(gallery).Error; err != nil {
+		return err
+	}
+	return indexGallery(gGorm.db, gallery.ID)
 }
 
 func (gGorm *galleryGorm) Update(gallery *Gallery) error {
-	return gGorm.db.Save(gallery).Error
+	if err := gGorm.db.Save(gallery).Error; err != nil {
+		return err
+	}
+	return indexGallery(gGorm.db, gallery.ID)
 }
 
 func (gGorm *galleryGorm) Delete(id uint) error {
@@ -135,6 +542,72 @@ func (gGorm *galleryGorm) Delete(id uint) error {
 	return gGorm.db.Delete(&gallery).Error
 }
 
+func (gGorm *galleryGorm) Restore(id uint) error {
+	db := gGorm.db.Unscoped().Model(&Gallery{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", gorm.Expr("NULL"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (gGorm *galleryGorm) Purge(id uint) error {
+	tx := gGorm.db.Begin()
+	err := tx.Where("gallery_id = ?", id).Delete(&GalleryTag{}).Error
+	if err == nil {
+		err = tx.Unscoped().Where("gallery_id = ?", id).Delete(&GalleryMember{}).Error
+	}
+	if err == nil {
+		err = tx.Unscoped().Where("gallery_id = ?", id).Delete(&ShareLink{}).Error
+	}
+	if err == nil {
+		err = tx.Unscoped().Where("gallery_id = ?", id).Delete(&Comment{}).Error
+	}
+	if err == nil {
+		err = tx.Where("gallery_id = ?", id).Delete(&GalleryStat{}).Error
+	}
+	if err == nil {
+		err = tx.Where("gallery_id = ?", id).Delete(&galleryVisit{}).Error
+	}
+	if err == nil {
+		err = tx.Unscoped().Delete(&Gallery{Model: gorm.Model{ID: id}}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (gGorm *galleryGorm) TrashedByID(id uint) (*Gallery, error) {
+	var gallery Gallery
+	db := gGorm.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
+func (gGorm *galleryGorm) TrashedByUserID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gGorm.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC").
+		Find(&galleries).Error
+	return galleries, err
+}
+
+func (gGorm *galleryGorm) TrashedBefore(deletedBefore time.Time) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gGorm.db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+		Order("deleted_at").
+		Find(&galleries).Error
+	return galleries, err
+}
+
 func (gGorm *galleryGorm) ByID(id uint) (*Gallery, error) {
 	var gallery Gallery
 	db := gGorm.db.Where("id = ?", id)
@@ -142,8 +615,200 @@ func (gGorm *galleryGorm) ByID(id uint) (*Gallery, error) {
 	return &gallery, err
 }
 
+func (gGorm *galleryGorm) ByPublicID(publicID string) (*Gallery, error) {
+	var gallery Gallery
+	db := gGorm.db.Where("public_id = ?", publicID)
+	err := first(db, &gallery)
+	return &gallery, err
+}
+
 func (gGorm *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	var galleries []Gallery
 	err := gGorm.db.Where("user_id = ?", userID).Find(&galleries).Error
 	return galleries, err
 }
+
+func (gGorm *galleryGorm) ByMemberID(userID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := gGorm.db.
+		Joins("JOIN gallery_members ON gallery_members.gallery_id = galleries.id AND gallery_members.deleted_at IS NULL").
+		Where("gallery_members.user_id = ? AND gallery_members.user_id <> 0", userID).
+		Find(&galleries).Error
+	return galleries, err
+}
+
+func (gGorm *galleryGorm) ByTagID(tagID uint, viewerID uint) ([]Gallery, error) {
+	var galleries []Gallery
+	err := whereListedTo(gGorm.db, viewerID).
+		Joins("JOIN gallery_tags ON gallery_tags.gallery_id = galleries.id").
+		Where("gallery_tags.tag_id = ?", tagID).
+		Order("galleries.updated_at DESC").
+		Find(&galleries).Error
+	return galleries, err
+}
+
+// gallerySortKeys are the expressions galleries are sorted by.
+var gallerySortKeys = map[string]string{
+	SortCreated:    "galleries.created_at",
+	SortUpdated:    "galleries.updated_at",
+	SortTitle:      "galleries.title",
+	SortImageCount: "(SELECT count(*) FROM images WHERE images.gallery_id = galleries.id)",
+}
+
+func (gGorm *galleryGorm) PageForUser(userID uint, query PageQuery) ([]Gallery, *Page, error) {
+	db := gGorm.db.Where("galleries.deleted_at IS NULL").
+		Where("galleries.user_id = ? OR galleries.id IN (?)", userID, memberGalleryIDs(gGorm.db, userID))
+	return pageGalleries(db, query)
+}
+
+func (gGorm *galleryGorm) PagePublic(filter PublicFilter, query PageQuery) ([]Gallery, *Page, error) {
+	db := wherePublic(gGorm.db)
+	if filter.UserID != 0 {
+		db = db.Where("galleries.user_id = ?", filter.UserID)
+	}
+	if filter.TagID != 0 {
+		db = db.Where("galleries.id IN (?)", gGorm.db.New().Table("gallery_tags").
+			Select("gallery_id").Where("tag_id = ?", filter.TagID).QueryExpr())
+	}
+	return pageGalleries(db, query)
+}
+
+// pageGalleries returns the page of the galleries selected by db which
+// query asks for, along with their number of images and owner's name.
+func pageGalleries(db *gorm.DB, query PageQuery) ([]Gallery, *Page, error) {
+	sortKey := gallerySortKeys[query.Sort]
+	db = db.Table("galleries").
+		Select("galleries.*, " + gallerySortKeys[SortImageCount] + " AS image_count, users.name AS owner_name").
+		Joins("LEFT JOIN users ON users.id = galleries.user_id")
+
+	// Pages before a cursor are read backwards from it and reversed.
+	reverse := query.Before != ""
+	order, comparison := "ASC", ">"
+	if (query.Order == OrderDesc) != reverse {
+		order, comparison = "DESC", "<"
+	}
+	if position := query.After + query.Before; position != "" {
+		c, err := decodeCursor(position)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := gallerySortValue(query.Sort, c.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		db = db.Where("("+sortKey+", galleries.id) "+comparison+" (?, ?)", key, c.ID)
+	}
+
+	var rows []struct {
+		Gallery
+		ImageCount int
+		OwnerName  string
+	}
+	err := db.Order(sortKey + " " + order + ", galleries.id " + order).
+		Limit(query.Limit + 1).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	more := len(rows) > query.Limit
+	if more {
+		rows = rows[:query.Limit]
+	}
+	if reverse {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := &Page{Sort: query.Sort, Order: query.Order}
+	galleries := make([]Gallery, len(rows))
+	for i, row := range rows {
+		galleries[i] = row.Gallery
+		galleries[i].OwnerName = row.OwnerName
+	}
+	if len(rows) == 0 {
+		return galleries, page, nil
+	}
+	first, last := rows[0], rows[len(rows)-1]
+	if more || query.Before != "" {
+		page.Next = encodeCursor(gallerySortText(query.Sort, &last.Gallery, last.ImageCount), last.ID)
+	}
+	if (more && reverse) || query.After != "" {
+		page.Prev = encodeCursor(gallerySortText(query.Sort, &first.Gallery, first.ImageCount), first.ID)
+	}
+	return galleries, page, nil
+}
+
+// gallerySortText is the sort key of a gallery as stored in cursors.
+func gallerySortText(sort string, gallery *Gallery, imageCount int) string {
+	switch sort {
+	case SortCreated:
+		return gallery.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case SortUpdated:
+		return gallery.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	case SortImageCount:
+		return strconv.Itoa(imageCount)
+	default:
+		return gallery.Title
+	}
+}
+
+// gallerySortValue parses the sort key of a cursor for comparing it
+// with gallerySortKeys.
+func gallerySortValue(sort string, text string) (interface{}, error) {
+	switch sort {
+	case SortCreated, SortUpdated:
+		key, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return key, nil
+	case SortImageCount:
+		key, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		return key, nil
+	default:
+		return text, nil
+	}
+}
+
+// publicGalleryCondition selects the galleries anyone may find in
+// listings: public galleries without a password. Its parameter is
+// VisibilityPublic.
+const publicGalleryCondition = "galleries.deleted_at IS NULL AND galleries.visibility = ? AND coalesce(galleries.password_hash, '') = ''"
+
+// wherePublic limits a query of, or joined with, galleries to those
+// anyone may find in listings.
+func wherePublic(db *gorm.DB) *gorm.DB {
+	return db.Where(publicGalleryCondition, VisibilityPublic)
+}
+
+// memberGalleryIDs selects the IDs of the galleries userID has
+// accepted an invitation to.
+func memberGalleryIDs(db *gorm.DB, userID uint) interface{} {
+	return db.New().Table("gallery_members").Select("gallery_id").
+		Where("user_id = ? AND user_id <> 0 AND deleted_at IS NULL", userID).QueryExpr()
+}
+
+// whereViewableBy limits a query joined with galleries to those
+// viewerID may open: their own, those they are a member of and
+// galleries which are neither private nor protected by a password.
+// Unlike whereListedTo, unlisted galleries are included, as their
+// images are reached through links.
+func whereViewableBy(db *gorm.DB, viewerID uint) *gorm.DB {
+	return db.Where("galleries.deleted_at IS NULL").
+		Where("galleries.user_id = ? OR (galleries.visibility <> ? AND coalesce(galleries.password_hash, '') = '') OR galleries.id IN (?)",
+			viewerID, VisibilityPrivate, memberGalleryIDs(db, viewerID))
+}
+
+// whereListedTo limits a query of, or joined with, galleries to those
+// viewerID may find in listings: their own, those they are a member
+// of and public galleries without a password. viewerID is 0 for
+// visitors who aren't signed in.
+func whereListedTo(db *gorm.DB, viewerID uint) *gorm.DB {
+	return db.Where("galleries.deleted_at IS NULL").
+		Where("galleries.user_id = ? OR (galleries.visibility = ? AND coalesce(galleries.password_hash, '') = '') OR galleries.id IN (?)",
+			viewerID, VisibilityPublic, memberGalleryIDs(db, viewerID))
+}
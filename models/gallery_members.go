@@ -0,0 +1,339 @@
+package models
+
+import (
+	"go-web-dev/hash"
+	"go-web-dev/rand"
+	"regexp"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// RoleViewer members can see the gallery whatever its visibility
+	RoleViewer = "viewer"
+	// RoleContributor members can also upload images
+	RoleContributor = "contributor"
+	// RoleEditor members can also rename the gallery, and reorder,
+	// pick the cover of and delete its images
+	RoleEditor = "editor"
+	// RoleOwner is the role of the gallery's UserID, who alone can
+	// change its visibility, password, share links and members, or
+	// delete it. Ownership can't be given to members.
+	RoleOwner = "owner"
+)
+
+// Permission is something a role in a gallery may allow.
+type Permission int
+
+const (
+	PermissionView Permission = iota
+	PermissionUpload
+	PermissionEdit
+	PermissionManage
+)
+
+// roleGrants is the greatest permission of each role. Roles also have
+// every permission below their own.
+var roleGrants = map[string]Permission{
+	RoleViewer:      PermissionView,
+	RoleContributor: PermissionUpload,
+	RoleEditor:      PermissionEdit,
+	RoleOwner:       PermissionManage,
+}
+
+// RoleAllows reports whether role has permission. The empty role,
+// used for visitors who aren't members, has none.
+func RoleAllows(role string, permission Permission) bool {
+	granted, ok := roleGrants[role]
+	return ok && permission <= granted
+}
+
+// GalleryMember gives a user a Role in a gallery they don't own.
+// Members are invited by Email and the invitation is accepted with its
+// Token, which sets UserID and clears the TokenHash.
+type GalleryMember struct {
+	gorm.Model
+	GalleryID   uint   `gorm:"not null;index"`
+	UserID      uint   `gorm:"index"`
+	Email       string `gorm:"not null"`
+	Role        string `gorm:"not null"`
+	InvitedByID uint
+	Token       string `gorm:"-"`
+	TokenHash   string `gorm:"index"`
+}
+
+// Pending reports whether the invitation is yet to be accepted.
+func (member *GalleryMember) Pending() bool {
+	return member.UserID == 0
+}
+
+type MemberService interface {
+	MemberDB
+
+	// Role returns the role of userID in the gallery, or "" when they
+	// aren't a member. Owners aren't members of their own galleries.
+	Role(galleryID, userID uint) (string, error)
+	// Accept makes user the member invited with token, provided the
+	// invitation was sent to their email address.
+	Accept(token string, user *User) (*GalleryMember, error)
+}
+
+func NewMemberService(db *gorm.DB, hmacSecretKey string) MemberService {
+	return &memberService{
+		MemberDB: &memberValidator{
+			MemberDB:   &memberGorm{db},
+			hmacKey:    hmacSecretKey,
+			emailRegex: regexp.MustCompile(emailPattern),
+		},
+	}
+}
+
+type memberService struct {
+	MemberDB
+}
+
+func (mService *memberService) Role(galleryID, userID uint) (string, error) {
+	member, err := mService.MemberDB.ByGalleryAndUser(galleryID, userID)
+	switch err {
+	case nil:
+		return member.Role, nil
+	case ErrNotFound:
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+func (mService *memberService) Accept(token string, user *User) (*GalleryMember, error) {
+	member, err := mService.MemberDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrInvalidInvitation
+		}
+		return nil, err
+	}
+	if member.Email != strings.ToLower(user.Email) {
+		return nil, ErrInvitationEmail
+	}
+	member.UserID = user.ID
+	member.TokenHash = ""
+	if err := mService.MemberDB.Update(member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+var _ MemberDB = &memberValidator{}
+
+type memberValidator struct {
+	MemberDB
+	// hmacKey signs invitation tokens
+	hmacKey    string
+	emailRegex *regexp.Regexp
+}
+
+func (mValidator *memberValidator) Create(member *GalleryMember) error {
+	err := runMemberValFuncs(member,
+		mValidator.requireGalleryID,
+		mValidator.normalizeEmail,
+		mValidator.requireEmail,
+		mValidator.emailFormat,
+		mValidator.validRole,
+		mValidator.duplicateEmail,
+		mValidator.generateToken,
+		mValidator.hashToken)
+	if err != nil {
+		return err
+	}
+	return mValidator.MemberDB.Create(member)
+}
+
+func (mValidator *memberValidator) Update(member *GalleryMember) error {
+	err := runMemberValFuncs(member,
+		mValidator.validateID,
+		mValidator.validRole)
+	if err != nil {
+		return err
+	}
+	return mValidator.MemberDB.Update(member)
+}
+
+func (mValidator *memberValidator) Delete(id uint) error {
+	var member GalleryMember
+	member.ID = id
+	if err := runMemberValFuncs(&member, mValidator.validateID); err != nil {
+		return err
+	}
+	return mValidator.MemberDB.Delete(member.ID)
+}
+
+func (mValidator *memberValidator) ByToken(token string) (*GalleryMember, error) {
+	member := GalleryMember{Token: token}
+	if err := runMemberValFuncs(&member, mValidator.hashToken); err != nil {
+		return nil, err
+	}
+	if member.TokenHash == "" {
+		return nil, ErrNotFound
+	}
+	return mValidator.MemberDB.ByToken(member.TokenHash)
+}
+
+type memberValFunc func(*GalleryMember) error
+
+func runMemberValFuncs(member *GalleryMember, funcs ...memberValFunc) error {
+	for _, function := range funcs {
+		if err := function(member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mValidator *memberValidator) requireGalleryID(member *GalleryMember) error {
+	if member.GalleryID <= 0 {
+		return ErrRequiredGalleryID
+	}
+	return nil
+}
+
+func (mValidator *memberValidator) normalizeEmail(member *GalleryMember) error {
+	member.Email = strings.TrimSpace(strings.ToLower(member.Email))
+	return nil
+}
+
+func (mValidator *memberValidator) requireEmail(member *GalleryMember) error {
+	if member.Email == "" {
+		return ErrRequiredEmail
+	}
+	return nil
+}
+
+func (mValidator *memberValidator) emailFormat(member *GalleryMember) error {
+	if !mValidator.emailRegex.MatchString(member.Email) {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// validRole rejects the owner role, which belongs to the gallery's
+// UserID alone.
+func (mValidator *memberValidator) validRole(member *GalleryMember) error {
+	switch member.Role {
+	case RoleViewer, RoleContributor, RoleEditor:
+		return nil
+	default:
+		return ErrInvalidRole
+	}
+}
+
+func (mValidator *memberValidator) duplicateEmail(member *GalleryMember) error {
+	_, err := mValidator.MemberDB.ByGalleryAndEmail(member.GalleryID, member.Email)
+	switch err {
+	case nil:
+		return ErrAlreadyMember
+	case ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+}
+
+func (mValidator *memberValidator) generateToken(member *GalleryMember) error {
+	if member.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	member.Token = token
+	return nil
+}
+
+func (mValidator *memberValidator) hashToken(member *GalleryMember) error {
+	if member.Token == "" {
+		return nil
+	}
+	member.TokenHash = hash.NewHMAC(mValidator.hmacKey).Hash(member.Token)
+	return nil
+}
+
+func (mValidator *memberValidator) validateID(member *GalleryMember) error {
+	if member.ID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+type MemberDB interface {
+	Create(member *GalleryMember) error
+	Update(member *GalleryMember) error
+	Delete(id uint) error
+
+	ByID(id uint) (*GalleryMember, error)
+	ByToken(token string) (*GalleryMember, error)
+	// ByGalleryID returns every member of the gallery, including
+	// pending invitations.
+	ByGalleryID(galleryID uint) ([]GalleryMember, error)
+	// ByUserID returns the accepted memberships of userID.
+	ByUserID(userID uint) ([]GalleryMember, error)
+	ByGalleryAndUser(galleryID, userID uint) (*GalleryMember, error)
+	ByGalleryAndEmail(galleryID uint, email string) (*GalleryMember, error)
+}
+
+var _ MemberDB = &memberGorm{}
+
+type memberGorm struct {
+	db *gorm.DB
+}
+
+func (mGorm *memberGorm) Create(member *GalleryMember) error {
+	return mGorm.db.Create(member).Error
+}
+
+func (mGorm *memberGorm) Update(member *GalleryMember) error {
+	return mGorm.db.Save(member).Error
+}
+
+func (mGorm *memberGorm) Delete(id uint) error {
+	member := GalleryMember{Model: gorm.Model{ID: id}}
+	return mGorm.db.Delete(&member).Error
+}
+
+func (mGorm *memberGorm) ByID(id uint) (*GalleryMember, error) {
+	var member GalleryMember
+	err := first(mGorm.db.Where("id = ?", id), &member)
+	return &member, err
+}
+
+func (mGorm *memberGorm) ByToken(tokenHash string) (*GalleryMember, error) {
+	var member GalleryMember
+	err := first(mGorm.db.Where("token_hash = ?", tokenHash), &member)
+	return &member, err
+}
+
+func (mGorm *memberGorm) ByGalleryID(galleryID uint) ([]GalleryMember, error) {
+	var members []GalleryMember
+	err := mGorm.db.Where("gallery_id = ?", galleryID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+func (mGorm *memberGorm) ByUserID(userID uint) ([]GalleryMember, error) {
+	var members []GalleryMember
+	err := mGorm.db.Where("user_id = ? AND user_id <> 0", userID).Find(&members).Error
+	return members, err
+}
+
+func (mGorm *memberGorm) ByGalleryAndUser(galleryID, userID uint) (*GalleryMember, error) {
+	var member GalleryMember
+	db := mGorm.db.Where("gallery_id = ? AND user_id = ? AND user_id <> 0", galleryID, userID)
+	err := first(db, &member)
+	return &member, err
+}
+
+func (mGorm *memberGorm) ByGalleryAndEmail(galleryID uint, email string) (*GalleryMember, error) {
+	var member GalleryMember
+	err := first(mGorm.db.Where("gallery_id = ? AND email = ?", galleryID, email), &member)
+	return &member, err
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"crypto/subtle"
+	"go-web-dev/hash"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minGalleryPasswordLen = 6
+	// failed password attempts allowed for a gallery within the window
+	maxPasswordAttempts   = 10
+	passwordAttemptWindow = 15 * time.Minute
+)
+
+// HasPassword reports whether visitors must enter a password.
+func (gallery *Gallery) HasPassword() bool {
+	return gallery.PasswordHash != ""
+}
+
+func (gService *galleryService) Unlock(gallery *Gallery, password string) error {
+	if !gallery.HasPassword() {
+		return nil
+	}
+	if !gService.attempts.Allow(gallery.ID) {
+		return ErrTooManyAttempts
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(gallery.PasswordHash), []byte(password+gService.pepper))
+	switch err {
+	case bcrypt.ErrMismatchedHashAndPassword:
+		gService.attempts.Fail(gallery.ID)
+		return ErrIncorrectPassword
+	default:
+		return err
+	}
+}
+
+// AccessToken signs the gallery ID and expiry along with the password
+// hash, so that changing the password revokes every token.
+func (gService *galleryService) AccessToken(gallery *Gallery, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expires + "." + gService.accessSignature(gallery, expires)
+}
+
+func (gService *galleryService) ValidAccessToken(gallery *Gallery, token string) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+	expected := gService.accessSignature(gallery, parts[0])
+	return subtle.ConstantTimeCompare([]byte(parts[1]), []byte(expected)) == 1
+}
+
+func (gService *galleryService) accessSignature(gallery *Gallery, expires string) string {
+	id := strconv.Itoa(int(gallery.ID))
+	signature := hash.NewHMAC(gService.hmacKey).Hash("gallery-access:" + id + ":" + expires + ":" + gallery.PasswordHash)
+	return strings.TrimRight(signature, "=")
+}
+
+// attemptLimiter counts failed attempts per key over a sliding window.
+// Counts are kept in memory, so each instance of the app throttles on
+// its own.
+type attemptLimiter struct {
+	mutex    sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[uint][]time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		failures: make(map[uint][]time.Time),
+	}
+}
+
+// Allow reports whether another attempt may be made for key.
+func (limiter *attemptLimiter) Allow(key uint) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	return len(limiter.prune(key, time.Now())) < limiter.max
+}
+
+// Fail records a failed attempt for key.
+func (limiter *attemptLimiter) Fail(key uint) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.failures[key] = append(limiter.prune(key, now), now)
+}
+
+// prune forgets the failures of key which fall outside of the window.
+func (limiter *attemptLimiter) prune(key uint, now time.Time) []time.Time {
+	failures := limiter.failures[key]
+	i := 0
+	for i < len(failures) && now.Sub(failures[i]) >= limiter.window {
+		i++
+	}
+	failures = failures[i:]
+	if len(failures) == 0 {
+		delete(limiter.failures, key)
+		return nil
+	}
+	limiter.failures[key] = failures
+	return failures
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"archive/zip"
+	"io"
+	"path"
+	"strings"
+)
+
+// ArchiveSummary reports what happened to each file of an uploaded
+// archive. Skipped files were not images and were left out.
+type ArchiveSummary struct {
+	Uploaded []string
+	Skipped  []string
+	Rejected FileErrors
+}
+
+// CreateFromArchive extracts the images of a ZIP archive into gallery,
+// running every entry through Create. Entries are never written to
+// disk under their own names; those pointing outside of the archive
+// are rejected all the same. Folders and macOS metadata are ignored
+// and anything else that isn't a JPEG or PNG is skipped.
+func (imgService *imageService) CreateFromArchive(gallery *Gallery, userID uint, r io.ReaderAt, size int64) (*ArchiveSummary, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrArchiveCorrupt
+	}
+	limits := imgService.limits
+	if limits.MaxArchiveEntries > 0 && len(archive.File) > limits.MaxArchiveEntries {
+		return nil, ErrArchiveTooManyFiles
+	}
+	// the reader refuses to return more than the declared size of an
+	// entry, so the declared sizes bound what we will extract
+	var total uint64
+	for _, file := range archive.File {
+		total += file.UncompressedSize64
+	}
+	if limits.MaxArchiveBytes > 0 && total > uint64(limits.MaxArchiveBytes) {
+		return nil, ErrArchiveTooLarge
+	}
+
+	var summary ArchiveSummary
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || archiveJunk(file.Name) {
+			continue
+		}
+		name, ok := archiveEntryName(file.Name)
+		if !ok {
+			summary.Rejected = append(summary.Rejected, FileError{Filename: file.Name, Err: ErrArchivePath})
+			continue
+		}
+		if !imageExtension(name) {
+			summary.Skipped = append(summary.Skipped, name)
+			continue
+		}
+		img := Image{
+			GalleryID:    gallery.ID,
+			UserID:       userID,
+			OriginalName: name,
+		}
+		if err := imgService.createFromEntry(gallery, &img, file); err != nil {
+			summary.Rejected = append(summary.Rejected, FileError{Filename: name, Err: err})
+			continue
+		}
+		summary.Uploaded = append(summary.Uploaded, name)
+	}
+	return &summary, nil
+}
+
+func (imgService *imageService) createFromEntry(gallery *Gallery, img *Image, file *zip.File) error {
+	if imgService.limits.MaxBytes > 0 && file.UncompressedSize64 > uint64(imgService.limits.MaxBytes) {
+		return ErrImageTooLarge
+	}
+	entry, err := file.Open()
+	if err != nil {
+		return ErrImageCorrupt
+	}
+	defer entry.Close()
+	return imgService.Create(gallery, img, entry)
+}
+
+// archiveEntryName returns the base name of an archive entry, failing
+// for absolute paths and paths which climb out of the archive.
+func archiveEntryName(name string) (string, bool) {
+	name = strings.Replace(name, "\\", "/", -1)
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsRune(name, 0) {
+		return "", false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	if len(name) > 1 && name[1] == ':' {
+		// windows drive letter
+		return "", false
+	}
+	return path.Base(name), true
+}
+
+// archiveJunk reports whether an entry is metadata added by the
+// operating system which created the archive.
+func archiveJunk(name string) bool {
+	name = strings.Replace(name, "\\", "/", -1)
+	base := path.Base(name)
+	return strings.HasPrefix(name, "__MACOSX/") || strings.Contains(name, "/__MACOSX/") ||
+		strings.HasPrefix(base, "._") || base == ".DS_Store" || base == "Thumbs.db"
+}
+
+func imageExtension(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"go-web-dev/exif"
+	"image"
+	"image/jpeg"
+	"strings"
+	"time"
+)
+
+const (
+	// quality used when an original has to be re-encoded, e.g. after
+	// rotating it upright
+	originalJPEGQuality = 95
+)
+
+// ExifSummary describes the camera settings the image was taken with,
+// e.g. "Canon EOS R5 · 50mm f/2.8 1/250s ISO 400 · Jul 4, 2021".
+func (img *Image) ExifSummary() string {
+	var parts, settings []string
+	if img.CameraModel != "" {
+		camera := img.CameraModel
+		if img.CameraMake != "" && !strings.HasPrefix(strings.ToLower(camera), strings.ToLower(img.CameraMake)) {
+			camera = img.CameraMake + " " + camera
+		}
+		parts = append(parts, camera)
+	}
+	if img.FocalLength > 0 {
+		settings = append(settings, fmt.Sprintf("%gmm", img.FocalLength))
+	}
+	if img.FNumber > 0 {
+		settings = append(settings, fmt.Sprintf("f/%g", img.FNumber))
+	}
+	if img.ExposureTime != "" {
+		settings = append(settings, img.ExposureTime+"s")
+	}
+	if img.ISO > 0 {
+		settings = append(settings, fmt.Sprintf("ISO %d", img.ISO))
+	}
+	if len(settings) > 0 {
+		parts = append(parts, strings.Join(settings, " "))
+	}
+	if img.TakenAt != nil {
+		parts = append(parts, img.TakenAt.Format("Jan 2, 2006"))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// HasLocation reports whether the photo recorded where it was taken.
+func (img *Image) HasLocation() bool {
+	return img.Latitude != nil && img.Longitude != nil
+}
+
+// capturedAt is when the photo was taken, falling back to when it
+// was uploaded.
+func (img *Image) capturedAt() time.Time {
+	if img.TakenAt != nil {
+		return *img.TakenAt
+	}
+	return img.CreatedAt
+}
+
+// processExif copies the exif metadata of a JPEG upload onto img and
+// returns the image data and pixels to store. Photos are rotated
+// upright according to their orientation, which re-encodes them and
+// so drops all of their metadata. Otherwise the exif data is stripped
+// from the stored file unless the gallery keeps locations, even when
+// it can't be read, so that no location slips through.
+func (imgService *imageService) processExif(gallery *Gallery, img *Image, data []byte, src image.Image) ([]byte, image.Image, error) {
+	info, err := exif.Decode(data)
+	if err == exif.ErrNoExif {
+		return data, src, nil
+	}
+	if err == nil {
+		img.CameraMake = info.Make
+		img.CameraModel = info.Model
+		img.TakenAt = info.TakenAt
+		img.ExposureTime = info.ExposureTime
+		img.FNumber = info.FNumber
+		img.ISO = info.ISO
+		img.FocalLength = info.FocalLength
+		img.Latitude = info.Latitude
+		img.Longitude = info.Longitude
+
+		if info.Orientation > 1 {
+			src = exif.Orient(src, info.Orientation)
+			data, err = encodeJPEG(src)
+			return data, src, err
+		}
+	}
+	if gallery.KeepLocation {
+		return data, src, nil
+	}
+	stripped, err := exif.Strip(data)
+	if err != nil {
+		// fall back to re-encoding, which never carries metadata
+		data, err = encodeJPEG(src)
+		return data, src, err
+	}
+	return stripped, src, nil
+}
+
+func encodeJPEG(src image.Image) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, src, &jpeg.Options{Quality: originalJPEGQuality}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+package models
+
+// Orphans are images left behind by galleries which no longer exist.
+// Images holds the rows of images whose gallery was deleted or never
+// existed, while Keys holds stored files which belong to no image at
+// all, such as the remains of an interrupted upload.
+type Orphans struct {
+	Images []Image
+	Keys   []string
+}
+
+// Empty reports whether nothing was found.
+func (orphans *Orphans) Empty() bool {
+	return len(orphans.Images) == 0 && len(orphans.Keys) == 0
+}
+
+// FindOrphans compares the image table against storage. Files stored
+// while this runs, by an upload in progress, may be reported as well.
+func (imgService *imageService) FindOrphans() (*Orphans, error) {
+	var orphans Orphans
+	var err error
+	orphans.Images, err = imgService.ImageDB.Orphaned()
+	if err != nil {
+		return nil, err
+	}
+	images, err := imgService.ImageDB.All()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := imgService.store.List(imageKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(images))
+	for _, img := range images {
+		known[img.Key()] = true
+		for _, size := range img.Variants {
+			known[img.VariantKey(int(size))] = true
+		}
+	}
+	for _, key := range keys {
+		if !known[key] {
+			orphans.Keys = append(orphans.Keys, key)
+		}
+	}
+	return &orphans, nil
+}
+
+// DeleteOrphans removes the images and files found by FindOrphans.
+func (imgService *imageService) DeleteOrphans(orphans *Orphans) error {
+	for i := range orphans.Images {
+		if err := imgService.Delete(&orphans.Images[i]); err != nil {
+			return err
+		}
+	}
+	for _, key := range orphans.Keys {
+		if err := imgService.store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	variantDir  = "variants"
+	jpegQuality = 85
+)
+
+// VariantKey returns the storage key of the resized copy of the image
+// whose long edge is size pixels.
+func (img *Image) VariantKey(size int) string {
+	return fmt.Sprintf("%v%v/%v/%d/%v", imageKeyPrefix, img.GalleryID, variantDir, size, img.Filename)
+}
+
+// VariantRoute returns the URL of the resized copy of the image whose
+// long edge is size pixels. The original is used when no such variant
+// exists, for instance when the upload was smaller than size.
+func (img *Image) VariantRoute(size int) string {
+	if !img.HasVariant(size) {
+		return img.Route()
+	}
+	urlObject := url.URL{
+		Path: imageRoutePrefix + img.VariantKey(size),
+	}
+	return urlObject.String()
+}
+
+func (img *Image) HasVariant(size int) bool {
+	for _, variant := range img.Variants {
+		if int(variant) == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Srcset lists every variant of the image along with the original, in
+// the format expected by the srcset attribute of an <img> tag.
+func (img *Image) Srcset() string {
+	candidates := make([]string, 0, len(img.Variants)+1)
+	for _, variant := range img.Variants {
+		width := int(variant)
+		if img.Height > img.Width && img.Height > 0 {
+			width = int(variant) * img.Width / img.Height
+		}
+		candidates = append(candidates, fmt.Sprintf("%v %dw", img.VariantRoute(int(variant)), width))
+	}
+	if img.Width > 0 {
+		candidates = append(candidates, fmt.Sprintf("%v %dw", img.Route(), img.Width))
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// createVariants writes a scaled copy of src for every configured
+// size smaller than its long edge. The sizes that were written are
+// recorded on img.Variants.
+func (imgService *imageService) createVariants(img *Image, src image.Image, format string) error {
+	bounds := src.Bounds()
+	longEdge := bounds.Dx()
+	if bounds.Dy() > longEdge {
+		longEdge = bounds.Dy()
+	}
+
+	img.Variants = nil
+	for _, size := range imgService.variantSizes {
+		if size <= 0 || size >= longEdge {
+			continue
+		}
+		width := bounds.Dx() * size / longEdge
+		height := bounds.Dy() * size / longEdge
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+		if err := imgService.writeVariant(img.VariantKey(size), dst, format); err != nil {
+			imgService.deleteVariants(img)
+			img.Variants = nil
+			return err
+		}
+		img.Variants = append(img.Variants, int64(size))
+	}
+	return nil
+}
+
+func (imgService *imageService) deleteVariants(img *Image) error {
+	for _, variant := range img.Variants {
+		if err := imgService.store.Delete(img.VariantKey(int(variant))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imgService *imageService) writeVariant(key string, dst image.Image, format string) error {
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, dst)
+	default:
+		err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return err
+	}
+	return imgService.store.Put(key, &buffer)
+}
+
+// normalizeVariantSizes drops invalid and duplicate sizes and orders
+// what remains from smallest to largest.
+func normalizeVariantSizes(sizes []int) []int {
+	result := make([]int, 0, len(sizes))
+	seen := make(map[int]bool)
+	for _, size := range sizes {
+		if size <= 0 || seen[size] {
+			continue
+		}
+		seen[size] = true
+		result = append(result, size)
+	}
+	sort.Ints(result)
+	return result
+}
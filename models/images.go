@@ -1,89 +1,717 @@
 package models
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"go-web-dev/rand"
+	"go-web-dev/storage"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
 )
 
 const (
-	imageDir = "images/galleries/"
+	maxOriginalNameLen = 255
+	// maxCaptionLen is the number of characters in an image caption
+	maxCaptionLen = 500
+
+	// imageKeyPrefix is prepended to the storage key of every image
+	imageKeyPrefix = "galleries/"
+	// imageRoutePrefix is where the application serves stored images
+	imageRoutePrefix = "/images/"
+	// ImageViewParam marks the requests of images opened at full size
+	ImageViewParam = "view"
 )
 
-// not stored in db (no GORM)
+// imageFormats maps the sniffed content types we accept to the name
+// of the format registered with the image package.
+var imageFormats = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+}
+
+// imageExtensions maps image formats to the extension used for the
+// generated filename of the stored file.
+var imageExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+}
+
+// filenameRegex matches the filenames generated for stored images.
+// Anything else arriving from a URL is rejected before it gets near
+// the storage backend.
+var filenameRegex = regexp.MustCompile(`^[0-9a-f]{32}\.(jpg|png)$`)
+
+// ValidFilename reports whether filename could have been generated
+// for a stored image.
+func ValidFilename(filename string) bool {
+	return filenameRegex.MatchString(filename)
+}
+
+// ImageLimits bounds the uploads accepted by the ImageService. A zero
+// value disables the corresponding check.
+type ImageLimits struct {
+	// MaxBytes is the largest file size accepted
+	MaxBytes int64
+	// MaxPixels is the largest width * height accepted, guarding
+	// against decompression bombs
+	MaxPixels int64
+	// MaxArchiveEntries is the most files an uploaded archive may hold
+	MaxArchiveEntries int
+	// MaxArchiveBytes is the largest total uncompressed size of an
+	// uploaded archive
+	MaxArchiveBytes int64
+}
+
+// Image is a photo belonging to a gallery. The file itself is kept by
+// the storage backend while its metadata is stored in the images
+// table.
 type Image struct {
-	GalleryID uint
-	Filename  string
+	gorm.Model
+	GalleryID uint `gorm:"not null;unique_index:gallery_filename"`
+	UserID    uint `gorm:"not null;index"`
+	// Filename is generated on upload and is the only name used for
+	// storage. The name the file was uploaded with is kept purely for
+	// display as OriginalName.
+	Filename     string `gorm:"not null;unique_index:gallery_filename"`
+	OriginalName string
+	// Caption is written by the gallery's editors and is searched along
+	// with the image's tags
+	Caption     string `gorm:"type:text"`
+	ContentType string `gorm:"not null"`
+	Size        int64  `gorm:"not null"`
+	Width       int
+	Height      int
+	Checksum    string `gorm:"not null"`
+	// Variants holds the long edge, in pixels, of every resized copy
+	// in storage
+	Variants pq.Int64Array `gorm:"type:integer[]"`
+	// Position orders the images of a gallery, with new uploads
+	// appended to the end
+	Position int `gorm:"not null;default:0"`
+	// The camera fields are read from the exif metadata of JPEG
+	// uploads and are empty otherwise
+	CameraMake   string
+	CameraModel  string
+	TakenAt      *time.Time
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	FocalLength  float64
+	Latitude     *float64
+	Longitude    *float64
+	Tags         []Tag     `gorm:"-"`
+	Comments     []Comment `gorm:"-"`
 }
 
-func (img *Image) Path() string {
-	return fmt.Sprintf("%v%v/%v", imageDir, img.GalleryID, img.Filename)
+// Key returns the storage key of the original image.
+func (img *Image) Key() string {
+	return fmt.Sprintf("%v%v/%v", imageKeyPrefix, img.GalleryID, img.Filename)
 }
 
 func (img *Image) Route() string {
 	urlObject := url.URL{
-		Path: "/" + img.Path(),
+		Path: imageRoutePrefix + img.Key(),
+	}
+	return urlObject.String()
+}
+
+// ViewRoute is the Route visitors open the image at full size by. It
+// is marked so that only opening it counts as a view of the image, and
+// not loading it in place of a missing variant.
+func (img *Image) ViewRoute() string {
+	urlObject := url.URL{
+		Path:     imageRoutePrefix + img.Key(),
+		RawQuery: ImageViewParam + "=1",
 	}
 	return urlObject.String()
 }
 
+// ImageStats summarizes the images held by a single gallery.
+type ImageStats struct {
+	GalleryID uint
+	Count     int
+	Bytes     int64
+}
+
+// SizeString returns Bytes in a human readable form (e.g. 4.2 MB).
+func (stats ImageStats) SizeString() string {
+	const unit = 1000
+	if stats.Bytes < unit {
+		return fmt.Sprintf("%d B", stats.Bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := stats.Bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(stats.Bytes)/float64(div), "kMGTPE"[exp])
+}
+
 type ImageService interface {
-	Create(galleryID uint, r io.Reader, filename string) error
+	// Create validates the contents of r, writes them to storage under
+	// a newly generated Filename and stores the image metadata.
+	// GalleryID and UserID must be set on img. JPEGs are rotated
+	// upright and lose their location unless the gallery keeps it.
+	Create(gallery *Gallery, img *Image, r io.Reader) error
+	CreateFromArchive(gallery *Gallery, userID uint, r io.ReaderAt, size int64) (*ArchiveSummary, error)
+	// Update stores changes to the image's Caption.
+	Update(img *Image) error
 	Delete(img *Image) error
 
+	// Open returns the contents of the image, or of its variant when
+	// size is not zero.
+	Open(img *Image, size int) (io.ReadCloser, error)
+	// URL returns a location clients can download the image, or its
+	// variant, from directly. An empty string means the image must be
+	// streamed using Open.
+	URL(img *Image, size int) string
+
+	ByFilename(galleryID uint, filename string) (*Image, error)
 	ByGalleryID(galleryID uint) ([]Image, error)
+	StatsByGalleryIDs(galleryIDs ...uint) (map[uint]ImageStats, error)
+	CoversByGalleries(galleries ...Gallery) (map[uint]Image, error)
+	ByTagID(tagID uint, viewerID uint, limit int) ([]Image, error)
+
+	// Reorder stores the position of every image in a gallery. filenames
+	// must list each image of the gallery exactly once.
+	Reorder(galleryID uint, filenames []string) error
+
+	FindOrphans() (*Orphans, error)
+	DeleteOrphans(orphans *Orphans) error
+}
+
+// NewImageService returns an ImageService which rejects uploads
+// outside of limits and writes a resized copy of every upload for
+// each of the given long edge sizes.
+func NewImageService(db *gorm.DB, store storage.Storage, limits ImageLimits, variantSizes ...int) ImageService {
+	return &imageService{
+		ImageDB: &imageValidator{
+			ImageDB: &imageGorm{db},
+		},
+		store:        store,
+		limits:       limits,
+		variantSizes: normalizeVariantSizes(variantSizes),
+	}
+}
+
+type imageService struct {
+	ImageDB
+	store        storage.Storage
+	limits       ImageLimits
+	variantSizes []int
+}
+
+func (imgService *imageService) Create(gallery *Gallery, img *Image, srcFile io.Reader) error {
+	data, err := imgService.readImage(srcFile)
+	if err != nil {
+		return err
+	}
+	src, format, err := imgService.decodeImage(data)
+	if err != nil {
+		return err
+	}
+	if format == "jpeg" {
+		data, src, err = imgService.processExif(gallery, img, data, src)
+		if err != nil {
+			return err
+		}
+	}
+
+	img.Filename, err = generateFilename(format)
+	if err != nil {
+		return err
+	}
+	img.OriginalName = normalizeOriginalName(img.OriginalName)
+
+	if err := imgService.store.Put(img.Key(), bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(data)
+	img.ContentType = http.DetectContentType(data)
+	img.Size = int64(len(data))
+	img.Checksum = hex.EncodeToString(checksum[:])
+	img.Width = src.Bounds().Dx()
+	img.Height = src.Bounds().Dy()
+
+	if err := imgService.createVariants(img, src, format); err != nil {
+		imgService.store.Delete(img.Key())
+		return err
+	}
+
+	if err := imgService.ImageDB.Create(img); err != nil {
+		imgService.deleteVariants(img)
+		imgService.store.Delete(img.Key())
+		return err
+	}
+	return nil
+}
+
+func (imgService *imageService) Delete(img *Image) error {
+	if err := imgService.ImageDB.Delete(img.ID); err != nil {
+		return err
+	}
+	if err := imgService.deleteVariants(img); err != nil {
+		return err
+	}
+	return imgService.store.Delete(img.Key())
+}
+
+func (imgService *imageService) Open(img *Image, size int) (io.ReadCloser, error) {
+	file, err := imgService.store.Get(imgService.key(img, size))
+	if err == storage.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (imgService *imageService) URL(img *Image, size int) string {
+	return imgService.store.URL(imgService.key(img, size))
+}
+
+// key returns the storage key of the image or of one of its variants.
+func (imgService *imageService) key(img *Image, size int) string {
+	if size == 0 {
+		return img.Key()
+	}
+	return img.VariantKey(size)
+}
+
+// readImage reads the upload into memory, refusing anything larger
+// than the configured limit.
+func (imgService *imageService) readImage(srcFile io.Reader) ([]byte, error) {
+	reader := srcFile
+	if imgService.limits.MaxBytes > 0 {
+		reader = io.LimitReader(srcFile, imgService.limits.MaxBytes+1)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if imgService.limits.MaxBytes > 0 && int64(len(data)) > imgService.limits.MaxBytes {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// decodeImage checks the upload is a supported image based on its
+// contents rather than its name. The header is inspected first so
+// that images with an excessive pixel count are rejected before any
+// memory is allocated for their pixels.
+func (imgService *imageService) decodeImage(data []byte) (image.Image, string, error) {
+	format, ok := imageFormats[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", ErrImageType
+	}
+	config, configFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || configFormat != format {
+		return nil, "", ErrImageCorrupt
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrImageCorrupt
+	}
+	if imgService.limits.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > imgService.limits.MaxPixels {
+		return nil, "", ErrImageDimensions
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrImageCorrupt
+	}
+	return src, format, nil
+}
+
+// generateFilename returns a random name for an image of the given
+// format, so uploads never collide or depend on client input.
+func generateFilename(format string) (string, error) {
+	bytes, err := rand.Bytes(16)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes) + imageExtensions[format], nil
 }
 
-func NewImageService() ImageService {
-	return &imageService{}
+// normalizeOriginalName strips any directories and control characters
+// from the name a file was uploaded with.
+func normalizeOriginalName(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if len(name) > maxOriginalNameLen {
+		name = name[:maxOriginalNameLen]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+	return name
 }
 
-type imageService struct{}
+var _ ImageDB = &imageValidator{}
 
-func (imgService *imageService) Create(galleryID uint, srcFile io.Reader, filename string) error {
-	galleryPath, err := imgService.imagePath(galleryID)
+type imageValidator struct {
+	ImageDB
+}
+
+func (imgValidator *imageValidator) Create(img *Image) error {
+	err := runImageValFuncs(img,
+		imgValidator.requireGalleryID,
+		imgValidator.requireUserID,
+		imgValidator.requireFilename,
+		imgValidator.validFilename,
+		imgValidator.requireChecksum)
 	if err != nil {
 		return err
 	}
+	return imgValidator.ImageDB.Create(img)
+}
 
-	dstFile, err := os.Create(galleryPath + filename)
+func (imgValidator *imageValidator) Update(img *Image) error {
+	err := runImageValFuncs(img,
+		imgValidator.validateID,
+		imgValidator.normalizeCaption,
+		imgValidator.captionLength)
 	if err != nil {
 		return err
 	}
-	defer dstFile.Close()
+	return imgValidator.ImageDB.Update(img)
+}
 
-	_, err = io.Copy(dstFile, srcFile)
+func (imgValidator *imageValidator) Delete(id uint) error {
+	var img Image
+	img.ID = id
+	err := runImageValFuncs(&img,
+		imgValidator.validateID)
 	if err != nil {
 		return err
 	}
+	return imgValidator.ImageDB.Delete(img.ID)
+}
+
+func (imgValidator *imageValidator) Reorder(galleryID uint, filenames []string) error {
+	seen := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		img := Image{GalleryID: galleryID, Filename: filename}
+		err := runImageValFuncs(&img,
+			imgValidator.requireGalleryID,
+			imgValidator.requireFilename,
+			imgValidator.validFilename)
+		if err != nil {
+			return err
+		}
+		if seen[filename] {
+			return ErrInvalidImageOrder
+		}
+		seen[filename] = true
+	}
+	return imgValidator.ImageDB.Reorder(galleryID, filenames)
+}
 
+func (imgValidator *imageValidator) ByFilename(galleryID uint, filename string) (*Image, error) {
+	img := Image{GalleryID: galleryID, Filename: filename}
+	err := runImageValFuncs(&img,
+		imgValidator.requireGalleryID,
+		imgValidator.requireFilename,
+		imgValidator.validFilename)
+	if err != nil {
+		return nil, err
+	}
+	return imgValidator.ImageDB.ByFilename(img.GalleryID, img.Filename)
+}
+
+type imageValFunc func(*Image) error
+
+func runImageValFuncs(img *Image, funcs ...imageValFunc) error {
+	for _, function := range funcs {
+		if err := function(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) requireGalleryID(img *Image) error {
+	if img.GalleryID <= 0 {
+		return ErrRequiredGalleryID
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) requireUserID(img *Image) error {
+	if img.UserID <= 0 {
+		return ErrRequiredUserID
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) requireFilename(img *Image) error {
+	if img.Filename == "" {
+		return ErrRequiredFilename
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) validFilename(img *Image) error {
+	if !ValidFilename(img.Filename) {
+		return ErrInvalidFilename
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) requireChecksum(img *Image) error {
+	if img.Checksum == "" {
+		return ErrRequiredChecksum
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) normalizeCaption(img *Image) error {
+	img.Caption = strings.TrimSpace(img.Caption)
+	return nil
+}
+
+func (imgValidator *imageValidator) captionLength(img *Image) error {
+	if utf8.RuneCountInString(img.Caption) > maxCaptionLen {
+		return ErrCaptionTooLong
+	}
+	return nil
+}
+
+func (imgValidator *imageValidator) validateID(img *Image) error {
+	if img.ID <= 0 {
+		return ErrInvalidID
+	}
 	return nil
 }
 
-func (imgService *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
-	galleryPath, _ := imgService.imagePath(galleryID)
-	filenames, err := filepath.Glob(galleryPath + "*")
+type ImageDB interface {
+	Create(img *Image) error
+	Update(img *Image) error
+	Delete(id uint) error
+
+	ByID(id uint) (*Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+	StatsByGalleryIDs(galleryIDs ...uint) (map[uint]ImageStats, error)
+	CoversByGalleries(galleries ...Gallery) (map[uint]Image, error)
+	// ByTagID returns up to limit images carrying the tag from the
+	// galleries viewerID may see listed, newest first.
+	ByTagID(tagID uint, viewerID uint, limit int) ([]Image, error)
+
+	Reorder(galleryID uint, filenames []string) error
+
+	// All returns every image, including those of deleted galleries
+	All() ([]Image, error)
+	// Orphaned returns the images whose gallery no longer exists.
+	// Images of galleries in the trash aren't orphaned.
+	Orphaned() ([]Image, error)
+}
+
+var _ ImageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+// Create appends the image to the end of its gallery unless it has
+// been given a position.
+func (imgGorm *imageGorm) Create(img *Image) error {
+	if img.Position == 0 {
+		row := imgGorm.db.Model(&Image{}).
+			Select("coalesce(max(position), 0) + 1").
+			Where("gallery_id = ?", img.GalleryID).
+			Row()
+		if err := row.Scan(&img.Position); err != nil {
+			return err
+		}
+	}
+	if err := imgGorm.db.Create(img).Error; err != nil {
+		return err
+	}
+	return indexImage(imgGorm.db, img.ID)
+}
+
+func (imgGorm *imageGorm) Update(img *Image) error {
+	if err := imgGorm.db.Save(img).Error; err != nil {
+		return err
+	}
+	return indexImage(imgGorm.db, img.ID)
+}
+
+// Delete removes the row entirely so that the table always mirrors
+// the files on disk.
+func (imgGorm *imageGorm) Delete(id uint) error {
+	if err := imgGorm.db.Where("image_id = ?", id).Delete(&ImageTag{}).Error; err != nil {
+		return err
+	}
+	if err := imgGorm.db.Where("image_id = ?", id).Delete(&CollectionImage{}).Error; err != nil {
+		return err
+	}
+	if err := imgGorm.db.Unscoped().Where("image_id = ?", id).Delete(&Comment{}).Error; err != nil {
+		return err
+	}
+	img := Image{Model: gorm.Model{ID: id}}
+	return imgGorm.db.Unscoped().Delete(&img).Error
+}
+
+func (imgGorm *imageGorm) ByID(id uint) (*Image, error) {
+	var img Image
+	db := imgGorm.db.Where("id = ?", id)
+	err := first(db, &img)
+	return &img, err
+}
+
+func (imgGorm *imageGorm) ByFilename(galleryID uint, filename string) (*Image, error) {
+	var img Image
+	db := imgGorm.db.Where("gallery_id = ?", galleryID).Where("filename = ?", filename)
+	err := first(db, &img)
+	return &img, err
+}
+
+func (imgGorm *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := imgGorm.db.Where("gallery_id = ?", galleryID).Order("position, created_at, id").Find(&images).Error
+	return images, err
+}
+
+func (imgGorm *imageGorm) All() ([]Image, error) {
+	var images []Image
+	err := imgGorm.db.Order("id").Find(&images).Error
+	return images, err
+}
+
+func (imgGorm *imageGorm) Orphaned() ([]Image, error) {
+	var images []Image
+	err := imgGorm.db.Select("images.*").
+		Joins("left join galleries on galleries.id = images.gallery_id").
+		Where("galleries.id is null").
+		Order("images.id").
+		Find(&images).Error
+	return images, err
+}
+
+func (imgGorm *imageGorm) StatsByGalleryIDs(galleryIDs ...uint) (map[uint]ImageStats, error) {
+	result := make(map[uint]ImageStats, len(galleryIDs))
+	if len(galleryIDs) == 0 {
+		return result, nil
+	}
+	var rows []ImageStats
+	err := imgGorm.db.Model(&Image{}).
+		Select("gallery_id, count(*) as count, coalesce(sum(size), 0) as bytes").
+		Where("gallery_id in (?)", galleryIDs).
+		Group("gallery_id").
+		Scan(&rows).Error
 	if err != nil {
 		return nil, err
 	}
-	result := make([]Image, len(filenames))
-	for i := range filenames {
-		result[i] = Image{
-			Filename:  strings.Replace(filenames[i], galleryPath, "", 1),
-			GalleryID: galleryID,
+	for _, row := range rows {
+		result[row.GalleryID] = row
+	}
+	return result, nil
+}
+
+// CoversByGalleries returns the cover image of each gallery keyed by
+// gallery ID. Galleries without a cover of their own use their first
+// image; empty galleries are left out.
+func (imgGorm *imageGorm) CoversByGalleries(galleries ...Gallery) (map[uint]Image, error) {
+	result := make(map[uint]Image, len(galleries))
+	var coverIDs []uint
+	for _, gallery := range galleries {
+		if gallery.CoverImageID != 0 {
+			coverIDs = append(coverIDs, gallery.CoverImageID)
 		}
 	}
+	if len(coverIDs) > 0 {
+		var covers []Image
+		if err := imgGorm.db.Where("id in (?)", coverIDs).Find(&covers).Error; err != nil {
+			return nil, err
+		}
+		for _, cover := range covers {
+			result[cover.GalleryID] = cover
+		}
+	}
+
+	var missing []uint
+	for _, gallery := range galleries {
+		if _, ok := result[gallery.ID]; !ok {
+			missing = append(missing, gallery.ID)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	var firsts []Image
+	err := imgGorm.db.Select("distinct on (gallery_id) *").
+		Where("gallery_id in (?)", missing).
+		Order("gallery_id, position, created_at, id").
+		Find(&firsts).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, first := range firsts {
+		result[first.GalleryID] = first
+	}
 	return result, nil
 }
 
-func (imgService *imageService) Delete(img *Image) error {
-	return os.Remove(img.Path())
+func (imgGorm *imageGorm) ByTagID(tagID uint, viewerID uint, limit int) ([]Image, error) {
+	var images []Image
+	err := whereListedTo(imgGorm.db.Select("images.*"), viewerID).
+		Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Joins("JOIN image_tags ON image_tags.image_id = images.id").
+		Where("image_tags.tag_id = ?", tagID).
+		Order("images.created_at DESC").
+		Limit(limit).
+		Find(&images).Error
+	return images, err
 }
 
-func (imgService *imageService) imagePath(galleryID uint) (string, error) {
-	galleryPath := fmt.Sprintf("%v%v/", imageDir, galleryID)
-	return galleryPath, os.MkdirAll(galleryPath, 0755)
+func (imgGorm *imageGorm) Reorder(galleryID uint, filenames []string) error {
+	var existing []string
+	err := imgGorm.db.Model(&Image{}).Where("gallery_id = ?", galleryID).Pluck("filename", &existing).Error
+	if err != nil {
+		return err
+	}
+	if len(existing) != len(filenames) {
+		return ErrInvalidImageOrder
+	}
+	positions := make(map[string]int, len(filenames))
+	for i, filename := range filenames {
+		positions[filename] = i + 1
+	}
+	for _, filename := range existing {
+		if _, ok := positions[filename]; !ok {
+			return ErrInvalidImageOrder
+		}
+	}
+
+	tx := imgGorm.db.Begin()
+	for filename, position := range positions {
+		err := tx.Model(&Image{}).
+			Where("gallery_id = ? AND filename = ?", galleryID, filename).
+			UpdateColumn("position", position).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
 }
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+const (
+	SortCreated    = "created"
+	SortUpdated    = "updated"
+	SortTitle      = "title"
+	SortImageCount = "images"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+
+	// defaultPageSize and maxPageSize bound the number of items on a
+	// page of a listing
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// GallerySorts are the orders galleries can be listed in.
+var GallerySorts = []string{SortUpdated, SortCreated, SortTitle, SortImageCount}
+
+// PageQuery selects a page of a listing. Pages are found by the cursor
+// of the item they follow (After) or precede (Before) rather than by
+// an offset, so that paging stays fast and doesn't skip or repeat
+// items when the listing changes in between. Zero values select the
+// first page in the listing's default order.
+type PageQuery struct {
+	Sort   string
+	Order  string
+	After  string
+	Before string
+	Limit  int
+}
+
+// Page holds the cursors of the pages around the one returned, which
+// are empty when there is no such page.
+type Page struct {
+	Sort  string
+	Order string
+	Next  string
+	Prev  string
+}
+
+// cursor is the position of an item in a listing: its sort key, as
+// text, and its ID to tell apart items with the same key.
+type cursor struct {
+	Key string `json:"k"`
+	ID  uint   `json:"id"`
+}
+
+func encodeCursor(key string, id uint) string {
+	data, _ := json.Marshal(cursor{Key: key, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// normalizePageQuery fills in the defaults of query, rejecting sorts
+// other than those given and cursors which can't be decoded.
+func normalizePageQuery(query *PageQuery, defaultSort string, sorts []string) error {
+	if query.Sort == "" {
+		query.Sort = defaultSort
+	}
+	valid := false
+	for _, sort := range sorts {
+		valid = valid || query.Sort == sort
+	}
+	if !valid {
+		return ErrInvalidSort
+	}
+	switch query.Order {
+	case "":
+		query.Order = OrderDesc
+		if query.Sort == SortTitle {
+			query.Order = OrderAsc
+		}
+	case OrderAsc, OrderDesc:
+	default:
+		return ErrInvalidSort
+	}
+	if query.Limit <= 0 {
+		query.Limit = defaultPageSize
+	}
+	if query.Limit > maxPageSize {
+		query.Limit = maxPageSize
+	}
+	if query.After != "" && query.Before != "" {
+		return ErrInvalidCursor
+	}
+	for _, value := range []string{query.After, query.Before} {
+		if value == "" {
+			continue
+		}
+		if _, err := decodeCursor(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
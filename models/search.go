@@ -0,0 +1,199 @@
+package models
+
+import (
+	"html"
+	"html/template"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// searchConfig is the Postgres text search configuration used to
+	// both index and query
+	searchConfig = "english"
+	// maxSearchQueryLen is the number of characters in a search
+	maxSearchQueryLen = 200
+	// searchGalleryLimit and searchImageLimit are the number of
+	// galleries and images a search returns
+	searchGalleryLimit = 20
+	searchImageLimit   = 48
+
+	// snippetStart and snippetStop delimit the matches in snippets.
+	// They can't appear in escaped text, so the snippets are escaped
+	// before they are replaced by <mark> tags.
+	snippetStart = "\x01"
+	snippetStop  = "\x02"
+)
+
+// gallerySearchVector weighs the title of a gallery above its tags,
+// and its tags above its description.
+const gallerySearchVector = `
+	setweight(to_tsvector('` + searchConfig + `', coalesce(galleries.title, '')), 'A') ||
+	setweight(to_tsvector('` + searchConfig + `', coalesce((
+		SELECT string_agg(tags.name, ' ') FROM tags
+		JOIN gallery_tags ON gallery_tags.tag_id = tags.id
+		WHERE gallery_tags.gallery_id = galleries.id), '')), 'B') ||
+	setweight(to_tsvector('` + searchConfig + `', coalesce(galleries.description, '')), 'C')`
+
+// imageSearchVector weighs the caption of an image above its tags,
+// and its tags above the name it was uploaded with.
+const imageSearchVector = `
+	setweight(to_tsvector('` + searchConfig + `', coalesce(images.caption, '')), 'A') ||
+	setweight(to_tsvector('` + searchConfig + `', coalesce((
+		SELECT string_agg(tags.name, ' ') FROM tags
+		JOIN image_tags ON image_tags.tag_id = tags.id
+		WHERE image_tags.image_id = images.id), '')), 'B') ||
+	setweight(to_tsvector('` + searchConfig + `', coalesce(images.original_name, '')), 'C')`
+
+// snippetOptions are the ts_headline options of search snippets.
+const snippetOptions = "StartSel=" + snippetStart + ", StopSel=" + snippetStop +
+	", MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// migrateSearch adds the search_vector columns and their GIN indexes,
+// which gorm can't declare, and indexes rows which don't have a
+// vector yet.
+func migrateSearch(db *gorm.DB) error {
+	statements := []string{
+		"ALTER TABLE galleries ADD COLUMN IF NOT EXISTS search_vector tsvector",
+		"ALTER TABLE images ADD COLUMN IF NOT EXISTS search_vector tsvector",
+		"CREATE INDEX IF NOT EXISTS idx_galleries_search_vector ON galleries USING GIN (search_vector)",
+		"CREATE INDEX IF NOT EXISTS idx_images_search_vector ON images USING GIN (search_vector)",
+		"UPDATE galleries SET search_vector = " + gallerySearchVector + " WHERE search_vector IS NULL",
+		"UPDATE images SET search_vector = " + imageSearchVector + " WHERE search_vector IS NULL",
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexGallery updates the search vector of the gallery. It must be
+// called whenever its title, description or tags change.
+func indexGallery(db *gorm.DB, galleryID uint) error {
+	return db.Exec("UPDATE galleries SET search_vector = "+gallerySearchVector+" WHERE id = ?", galleryID).Error
+}
+
+// indexImage updates the search vector of the image. It must be called
+// whenever its caption or tags change.
+func indexImage(db *gorm.DB, imageID uint) error {
+	return db.Exec("UPDATE images SET search_vector = "+imageSearchVector+" WHERE id = ?", imageID).Error
+}
+
+// SearchResults are the galleries and images matching Query, best
+// matches first.
+type SearchResults struct {
+	Query     string
+	Galleries []GalleryResult
+	Images    []ImageResult
+}
+
+// Empty reports whether nothing matched.
+func (results *SearchResults) Empty() bool {
+	return len(results.Galleries) == 0 && len(results.Images) == 0
+}
+
+// GalleryResult is a gallery matching a search. Snippet is an excerpt
+// of its title and description with the matches delimited.
+type GalleryResult struct {
+	Gallery
+	Rank    float64
+	Snippet string
+}
+
+// SnippetHTML returns the snippet with the matches highlighted.
+func (result *GalleryResult) SnippetHTML() template.HTML {
+	return highlightSnippet(result.Snippet)
+}
+
+// ImageResult is an image matching a search. Snippet is an excerpt of
+// its caption, or its original name when it has none, with the
+// matches delimited.
+type ImageResult struct {
+	Image
+	Rank    float64
+	Snippet string
+}
+
+// SnippetHTML returns the snippet with the matches highlighted.
+func (result *ImageResult) SnippetHTML() template.HTML {
+	return highlightSnippet(result.Snippet)
+}
+
+// highlightSnippet escapes a snippet returned by ts_headline and
+// wraps its matches in <mark> tags.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.Replace(escaped, snippetStart, "<mark>", -1)
+	escaped = strings.Replace(escaped, snippetStop, "</mark>", -1)
+	return template.HTML(escaped)
+}
+
+type SearchService interface {
+	// Search finds the galleries and images matching query which
+	// viewerID, or visitors when 0, may see listed. Queries follow
+	// the web search syntax: quoted phrases, "or" and -excluded words.
+	Search(query string, viewerID uint) (*SearchResults, error)
+}
+
+func NewSearchService(db *gorm.DB) SearchService {
+	return &searchValidator{
+		SearchService: &searchGorm{db},
+	}
+}
+
+type searchValidator struct {
+	SearchService
+}
+
+// Search doesn't query the database for blank searches, which match
+// nothing.
+func (sValidator *searchValidator) Search(query string, viewerID uint) (*SearchResults, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	if utf8.RuneCountInString(query) > maxSearchQueryLen {
+		return nil, ErrSearchQueryTooLong
+	}
+	if query == "" {
+		return &SearchResults{}, nil
+	}
+	return sValidator.SearchService.Search(query, viewerID)
+}
+
+var _ SearchService = &searchGorm{}
+
+type searchGorm struct {
+	db *gorm.DB
+}
+
+func (sGorm *searchGorm) Search(query string, viewerID uint) (*SearchResults, error) {
+	results := SearchResults{Query: query}
+	err := whereListedTo(sGorm.db.Table("galleries"), viewerID).
+		Select("galleries.*, ts_rank(galleries.search_vector, search_query) AS rank, "+
+			"ts_headline('"+searchConfig+"', galleries.title || ' ' || coalesce(galleries.description, ''), search_query, ?) AS snippet",
+			snippetOptions).
+		Joins("CROSS JOIN websearch_to_tsquery('"+searchConfig+"', ?) AS search_query", query).
+		Where("galleries.search_vector @@ search_query").
+		Order("rank DESC, galleries.updated_at DESC").
+		Limit(searchGalleryLimit).
+		Scan(&results.Galleries).Error
+	if err != nil {
+		return nil, err
+	}
+	err = whereListedTo(sGorm.db.Table("images"), viewerID).
+		Select("images.*, ts_rank(images.search_vector, search_query) AS rank, "+
+			"ts_headline('"+searchConfig+"', coalesce(nullif(images.caption, ''), images.original_name, ''), search_query, ?) AS snippet",
+			snippetOptions).
+		Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Joins("CROSS JOIN websearch_to_tsquery('"+searchConfig+"', ?) AS search_query", query).
+		Where("images.search_vector @@ search_query").
+		Order("rank DESC, images.created_at DESC").
+		Limit(searchImageLimit).
+		Scan(&results.Images).Error
+	if err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
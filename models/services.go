@@ -1,16 +1,26 @@
 package models
 
 import (
+	"go-web-dev/storage"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
 type Services struct {
-	OAuth   OAuthService
-	Gallery GalleryService
-	User    UserService
-	Image   ImageService
-	db      *gorm.DB
+	OAuth      OAuthService
+	Gallery    GalleryService
+	User       UserService
+	Image      ImageService
+	ShareLink  ShareLinkService
+	Member     MemberService
+	Tag        TagService
+	Search     SearchService
+	Comment    CommentService
+	Collection CollectionService
+	Follow     FollowService
+	Analytics  AnalyticsService
+	db         *gorm.DB
 }
 
 type ServicesConfig func(*Services) error
@@ -40,9 +50,14 @@ func WithOAuthService() ServicesConfig {
 	}
 }
 
-func WithGalleryService() ServicesConfig {
+// WithGalleryService must follow WithImageService, which it uses to
+// delete the images of galleries purged from the trash.
+func WithGalleryService(pepper string, hmacSecretKey string) ServicesConfig {
 	return func(services *Services) error {
-		services.Gallery = NewGalleryService(services.db)
+		if services.Image == nil {
+			return ErrRequiredImageService
+		}
+		services.Gallery = NewGalleryService(services.db, services.Image, pepper, hmacSecretKey)
 		return nil
 	}
 }
@@ -54,9 +69,65 @@ func WithUserService(pepper string, hmacSecretKey string) ServicesConfig {
 	}
 }
 
-func WithImageService() ServicesConfig {
+func WithImageService(store storage.Storage, limits ImageLimits, variantSizes ...int) ServicesConfig {
+	return func(services *Services) error {
+		services.Image = NewImageService(services.db, store, limits, variantSizes...)
+		return nil
+	}
+}
+
+func WithShareLinkService(hmacSecretKey string) ServicesConfig {
+	return func(services *Services) error {
+		services.ShareLink = NewShareLinkService(services.db, hmacSecretKey)
+		return nil
+	}
+}
+
+func WithMemberService(hmacSecretKey string) ServicesConfig {
+	return func(services *Services) error {
+		services.Member = NewMemberService(services.db, hmacSecretKey)
+		return nil
+	}
+}
+
+func WithTagService() ServicesConfig {
+	return func(services *Services) error {
+		services.Tag = NewTagService(services.db)
+		return nil
+	}
+}
+
+func WithSearchService() ServicesConfig {
 	return func(services *Services) error {
-		services.Image = NewImageService()
+		services.Search = NewSearchService(services.db)
+		return nil
+	}
+}
+
+func WithCommentService() ServicesConfig {
+	return func(services *Services) error {
+		services.Comment = NewCommentService(services.db)
+		return nil
+	}
+}
+
+func WithCollectionService() ServicesConfig {
+	return func(services *Services) error {
+		services.Collection = NewCollectionService(services.db)
+		return nil
+	}
+}
+
+func WithFollowService() ServicesConfig {
+	return func(services *Services) error {
+		services.Follow = NewFollowService(services.db)
+		return nil
+	}
+}
+
+func WithAnalyticsService(hmacSecretKey string) ServicesConfig {
+	return func(services *Services) error {
+		services.Analytics = NewAnalyticsService(services.db, hmacSecretKey)
 		return nil
 	}
 }
@@ -77,11 +148,15 @@ func (services *Services) Close() error {
 }
 
 func (services *Services) AutoMigrate() error {
-	return services.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{}, &OAuth{}).Error
+	err := services.db.AutoMigrate(&User{}, &Gallery{}, &pwReset{}, &OAuth{}, &Image{}, &ShareLink{}, &GalleryMember{}, &Tag{}, &GalleryTag{}, &ImageTag{}, &Comment{}, &Collection{}, &CollectionImage{}, &Follow{}, &galleryVisit{}, &GalleryStat{}).Error
+	if err != nil {
+		return err
+	}
+	return migrateSearch(services.db)
 }
 
 func (services *Services) DestructiveReset() error {
-	if err := services.db.DropTableIfExists(&User{}, &Gallery{}, &pwReset{}, &OAuth{}).Error; err != nil {
+	if err := services.db.DropTableIfExists(&User{}, &Gallery{}, &pwReset{}, &OAuth{}, &Image{}, &ShareLink{}, &GalleryMember{}, &Tag{}, &GalleryTag{}, &ImageTag{}, &Comment{}, &Collection{}, &CollectionImage{}, &Follow{}, &galleryVisit{}, &GalleryStat{}).Error; err != nil {
 		return err
 	}
 	return services.AutoMigrate()
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"crypto/subtle"
+	"go-web-dev/hash"
+	"go-web-dev/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const shareNonceBytes = 32
+
+// ShareLink grants access to a gallery, whatever its visibility, to
+// anyone holding its token. The token is an HMAC signature of the
+// link's ID and Nonce so it never has to be stored, yet can be shown
+// to the owner again. Revoking a link deletes it.
+type ShareLink struct {
+	gorm.Model
+	GalleryID     uint   `gorm:"not null;index"`
+	Nonce         string `gorm:"not null"`
+	Token         string `gorm:"-"`
+	Label         string
+	ExpiresAt     *time.Time
+	AllowDownload bool `gorm:"not null;default:false"`
+	Views         int  `gorm:"not null;default:0"`
+	LastViewedAt  *time.Time
+}
+
+// Expired reports whether the link can no longer be used.
+func (link *ShareLink) Expired() bool {
+	return link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt)
+}
+
+// Path is the page the link leads to.
+func (link *ShareLink) Path() string {
+	return "/s/" + link.Token
+}
+
+type ShareLinkService interface {
+	ShareLinkDB
+
+	// ByToken returns the unexpired link a token was issued for.
+	ByToken(token string) (*ShareLink, error)
+	// RecordView counts a visit to the link's page.
+	RecordView(link *ShareLink) error
+}
+
+func NewShareLinkService(db *gorm.DB, hmacSecretKey string) ShareLinkService {
+	signer := shareLinkSigner{key: hmacSecretKey}
+	return &shareLinkService{
+		ShareLinkDB: &shareLinkValidator{
+			ShareLinkDB: &shareLinkGorm{db},
+			signer:      signer,
+		},
+		signer: signer,
+	}
+}
+
+type shareLinkService struct {
+	ShareLinkDB
+	signer shareLinkSigner
+}
+
+func (linkService *shareLinkService) ByToken(token string) (*ShareLink, error) {
+	idStr := strings.SplitN(token, ".", 2)[0]
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil || id == 0 {
+		return nil, ErrNotFound
+	}
+	link, err := linkService.ShareLinkDB.ByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(link.Token)) != 1 {
+		return nil, ErrNotFound
+	}
+	if link.Expired() {
+		return nil, ErrShareLinkExpired
+	}
+	return link, nil
+}
+
+func (linkService *shareLinkService) RecordView(link *ShareLink) error {
+	now := time.Now()
+	link.Views++
+	link.LastViewedAt = &now
+	return linkService.ShareLinkDB.IncrementViews(link.ID, now)
+}
+
+// shareLinkSigner derives link tokens.
+type shareLinkSigner struct {
+	key string
+}
+
+func (signer shareLinkSigner) token(link *ShareLink) string {
+	id := strconv.Itoa(int(link.ID))
+	signature := hash.NewHMAC(signer.key).Hash("share:" + id + ":" + link.Nonce)
+	return id + "." + strings.TrimRight(signature, "=")
+}
+
+var _ ShareLinkDB = &shareLinkValidator{}
+
+type shareLinkValidator struct {
+	ShareLinkDB
+	signer shareLinkSigner
+}
+
+func (linkValidator *shareLinkValidator) Create(link *ShareLink) error {
+	err := runShareLinkValFuncs(link,
+		linkValidator.requireGalleryID,
+		linkValidator.expiresInFuture,
+		linkValidator.generateNonce)
+	if err != nil {
+		return err
+	}
+	if err := linkValidator.ShareLinkDB.Create(link); err != nil {
+		return err
+	}
+	link.Token = linkValidator.signer.token(link)
+	return nil
+}
+
+func (linkValidator *shareLinkValidator) Delete(id uint) error {
+	var link ShareLink
+	link.ID = id
+	err := runShareLinkValFuncs(&link,
+		linkValidator.validateID)
+	if err != nil {
+		return err
+	}
+	return linkValidator.ShareLinkDB.Delete(link.ID)
+}
+
+func (linkValidator *shareLinkValidator) ByID(id uint) (*ShareLink, error) {
+	link, err := linkValidator.ShareLinkDB.ByID(id)
+	if err != nil {
+		return nil, err
+	}
+	link.Token = linkValidator.signer.token(link)
+	return link, nil
+}
+
+func (linkValidator *shareLinkValidator) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	links, err := linkValidator.ShareLinkDB.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].Token = linkValidator.signer.token(&links[i])
+	}
+	return links, nil
+}
+
+type shareLinkValFunc func(*ShareLink) error
+
+func runShareLinkValFuncs(link *ShareLink, funcs ...shareLinkValFunc) error {
+	for _, function := range funcs {
+		if err := function(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (linkValidator *shareLinkValidator) requireGalleryID(link *ShareLink) error {
+	if link.GalleryID <= 0 {
+		return ErrRequiredGalleryID
+	}
+	return nil
+}
+
+func (linkValidator *shareLinkValidator) expiresInFuture(link *ShareLink) error {
+	if link.Expired() {
+		return ErrInvalidExpiry
+	}
+	return nil
+}
+
+func (linkValidator *shareLinkValidator) generateNonce(link *ShareLink) error {
+	if link.Nonce != "" {
+		return nil
+	}
+	nonce, err := rand.String(shareNonceBytes)
+	if err != nil {
+		return err
+	}
+	link.Nonce = nonce
+	return nil
+}
+
+func (linkValidator *shareLinkValidator) validateID(link *ShareLink) error {
+	if link.ID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+type ShareLinkDB interface {
+	Create(link *ShareLink) error
+	Delete(id uint) error
+	IncrementViews(id uint, at time.Time) error
+
+	ByID(id uint) (*ShareLink, error)
+	ByGalleryID(galleryID uint) ([]ShareLink, error)
+}
+
+var _ ShareLinkDB = &shareLinkGorm{}
+
+type shareLinkGorm struct {
+	db *gorm.DB
+}
+
+func (linkGorm *shareLinkGorm) Create(link *ShareLink) error {
+	return linkGorm.db.Create(link).Error
+}
+
+func (linkGorm *shareLinkGorm) Delete(id uint) error {
+	link := ShareLink{Model: gorm.Model{ID: id}}
+	return linkGorm.db.Delete(&link).Error
+}
+
+// IncrementViews counts in the database so that concurrent views are
+// all counted.
+func (linkGorm *shareLinkGorm) IncrementViews(id uint, at time.Time) error {
+	return linkGorm.db.Model(&ShareLink{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"views":          gorm.Expr("views + 1"),
+		"last_viewed_at": at,
+	}).Error
+}
+
+func (linkGorm *shareLinkGorm) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	db := linkGorm.db.Where("id = ?", id)
+	err := first(db, &link)
+	return &link, err
+}
+
+func (linkGorm *shareLinkGorm) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := linkGorm.db.Where("gallery_id = ?", galleryID).Order("created_at desc").Find(&links).Error
+	return links, err
+}
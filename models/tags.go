@@ -0,0 +1,292 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// maxTags is the number of tags a gallery or image may have
+	maxTags = 20
+	// maxTagLen is the number of characters in a tag
+	maxTagLen = 32
+)
+
+// Tag groups galleries and images. Names are stored normalized, see
+// NormalizeTag, so that each tag exists once. GalleryCount and
+// ImageCount are filled in by CountVisible.
+type Tag struct {
+	gorm.Model
+	Name         string `gorm:"not null;unique_index"`
+	GalleryCount int    `gorm:"-"`
+	ImageCount   int    `gorm:"-"`
+}
+
+// Path is the page listing what carries the tag.
+func (tag *Tag) Path() string {
+	return "/tags/" + tag.Name
+}
+
+// GalleryTag links a gallery to one of its tags.
+type GalleryTag struct {
+	GalleryID uint `gorm:"primary_key;auto_increment:false"`
+	TagID     uint `gorm:"primary_key;auto_increment:false;index"`
+}
+
+// ImageTag links an image to one of its tags.
+type ImageTag struct {
+	ImageID uint `gorm:"primary_key;auto_increment:false"`
+	TagID   uint `gorm:"primary_key;auto_increment:false;index"`
+}
+
+// NormalizeTag folds name to the form tags are stored in: trimmed,
+// lower case, without a leading '#' and with runs of whitespace
+// replaced by a single '-'.
+func NormalizeTag(name string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "#")
+	return strings.ToLower(strings.Join(strings.Fields(name), "-"))
+}
+
+// ParseTags splits tags typed as a comma separated list.
+func ParseTags(input string) []string {
+	return strings.Split(input, ",")
+}
+
+// JoinTags is the inverse of ParseTags, for filling in forms.
+func JoinTags(tags []Tag) string {
+	names := make([]string, len(tags))
+	for i, tag := range tags {
+		names[i] = tag.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// TagList lists the gallery's tags for editing.
+func (gallery *Gallery) TagList() string {
+	return JoinTags(gallery.Tags)
+}
+
+// TagList lists the image's tags for editing.
+func (img *Image) TagList() string {
+	return JoinTags(img.Tags)
+}
+
+type TagService interface {
+	TagDB
+}
+
+func NewTagService(db *gorm.DB) TagService {
+	return &tagService{
+		TagDB: &tagValidator{
+			TagDB: &tagGorm{db},
+		},
+	}
+}
+
+type tagService struct {
+	TagDB
+}
+
+var _ TagDB = &tagValidator{}
+
+type tagValidator struct {
+	TagDB
+}
+
+func (tValidator *tagValidator) ByName(name string) (*Tag, error) {
+	return tValidator.TagDB.ByName(NormalizeTag(name))
+}
+
+func (tValidator *tagValidator) SetGalleryTags(galleryID uint, names []string) ([]Tag, error) {
+	if galleryID <= 0 {
+		return nil, ErrRequiredGalleryID
+	}
+	names, err := NormalizeTags(names)
+	if err != nil {
+		return nil, err
+	}
+	return tValidator.TagDB.SetGalleryTags(galleryID, names)
+}
+
+func (tValidator *tagValidator) SetImageTags(imageID uint, names []string) ([]Tag, error) {
+	if imageID <= 0 {
+		return nil, ErrInvalidID
+	}
+	names, err := NormalizeTags(names)
+	if err != nil {
+		return nil, err
+	}
+	return tValidator.TagDB.SetImageTags(imageID, names)
+}
+
+// NormalizeTags normalizes each name, leaving out empty names and
+// duplicates, and checks that the tags are valid.
+func NormalizeTags(names []string) ([]string, error) {
+	seen := make(map[string]bool, len(names))
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		name = NormalizeTag(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		if utf8.RuneCountInString(name) > maxTagLen {
+			return nil, ErrTagTooLong
+		}
+		for _, r := range name {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+				return nil, ErrInvalidTag
+			}
+		}
+		seen[name] = true
+		result = append(result, name)
+	}
+	if len(result) > maxTags {
+		return nil, ErrTooManyTags
+	}
+	return result, nil
+}
+
+type TagDB interface {
+	ByName(name string) (*Tag, error)
+	// ByGalleryIDs returns the tags of each gallery in alphabetical
+	// order, keyed by gallery ID.
+	ByGalleryIDs(galleryIDs ...uint) (map[uint][]Tag, error)
+	// ByImageIDs returns the tags of each image in alphabetical order,
+	// keyed by image ID.
+	ByImageIDs(imageIDs ...uint) (map[uint][]Tag, error)
+	// CountVisible fills in the number of galleries and images carrying
+	// the tag which viewerID, or visitors when 0, may see listed.
+	CountVisible(tag *Tag, viewerID uint) error
+
+	// SetGalleryTags replaces the tags of the gallery by the tags
+	// named, creating those that don't exist yet.
+	SetGalleryTags(galleryID uint, names []string) ([]Tag, error)
+	// SetImageTags replaces the tags of the image by the tags named,
+	// creating those that don't exist yet.
+	SetImageTags(imageID uint, names []string) ([]Tag, error)
+}
+
+var _ TagDB = &tagGorm{}
+
+type tagGorm struct {
+	db *gorm.DB
+}
+
+func (tGorm *tagGorm) ByName(name string) (*Tag, error) {
+	var tag Tag
+	err := first(tGorm.db.Where("name = ?", name), &tag)
+	return &tag, err
+}
+
+func (tGorm *tagGorm) ByGalleryIDs(galleryIDs ...uint) (map[uint][]Tag, error) {
+	result := make(map[uint][]Tag, len(galleryIDs))
+	if len(galleryIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		Tag
+		GalleryID uint
+	}
+	err := tGorm.db.Table("tags").
+		Select("tags.*, gallery_tags.gallery_id").
+		Joins("JOIN gallery_tags ON gallery_tags.tag_id = tags.id").
+		Where("gallery_tags.gallery_id IN (?) AND tags.deleted_at IS NULL", galleryIDs).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.GalleryID] = append(result[row.GalleryID], row.Tag)
+	}
+	return result, nil
+}
+
+func (tGorm *tagGorm) ByImageIDs(imageIDs ...uint) (map[uint][]Tag, error) {
+	result := make(map[uint][]Tag, len(imageIDs))
+	if len(imageIDs) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		Tag
+		ImageID uint
+	}
+	err := tGorm.db.Table("tags").
+		Select("tags.*, image_tags.image_id").
+		Joins("JOIN image_tags ON image_tags.tag_id = tags.id").
+		Where("image_tags.image_id IN (?) AND tags.deleted_at IS NULL", imageIDs).
+		Order("tags.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		result[row.ImageID] = append(result[row.ImageID], row.Tag)
+	}
+	return result, nil
+}
+
+func (tGorm *tagGorm) CountVisible(tag *Tag, viewerID uint) error {
+	galleries := whereListedTo(tGorm.db.Model(&Gallery{}), viewerID).
+		Joins("JOIN gallery_tags ON gallery_tags.gallery_id = galleries.id").
+		Where("gallery_tags.tag_id = ?", tag.ID)
+	if err := galleries.Count(&tag.GalleryCount).Error; err != nil {
+		return err
+	}
+	images := whereListedTo(tGorm.db.Model(&Image{}), viewerID).
+		Joins("JOIN galleries ON galleries.id = images.gallery_id").
+		Joins("JOIN image_tags ON image_tags.image_id = images.id").
+		Where("image_tags.tag_id = ?", tag.ID)
+	return images.Count(&tag.ImageCount).Error
+}
+
+func (tGorm *tagGorm) SetGalleryTags(galleryID uint, names []string) ([]Tag, error) {
+	tx := tGorm.db.Begin()
+	tags, err := findOrCreateTags(tx, names)
+	if err == nil {
+		err = tx.Where("gallery_id = ?", galleryID).Delete(&GalleryTag{}).Error
+	}
+	for i := 0; err == nil && i < len(tags); i++ {
+		err = tx.Create(&GalleryTag{GalleryID: galleryID, TagID: tags[i].ID}).Error
+	}
+	if err == nil {
+		err = indexGallery(tx, galleryID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tags, tx.Commit().Error
+}
+
+func (tGorm *tagGorm) SetImageTags(imageID uint, names []string) ([]Tag, error) {
+	tx := tGorm.db.Begin()
+	tags, err := findOrCreateTags(tx, names)
+	if err == nil {
+		err = tx.Where("image_id = ?", imageID).Delete(&ImageTag{}).Error
+	}
+	for i := 0; err == nil && i < len(tags); i++ {
+		err = tx.Create(&ImageTag{ImageID: imageID, TagID: tags[i].ID}).Error
+	}
+	if err == nil {
+		err = indexImage(tx, imageID)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tags, tx.Commit().Error
+}
+
+func findOrCreateTags(tx *gorm.DB, names []string) ([]Tag, error) {
+	tags := make([]Tag, len(names))
+	for i, name := range names {
+		if err := tx.Where(Tag{Name: name}).FirstOrCreate(&tags[i]).Error; err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
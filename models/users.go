@@ -13,6 +13,10 @@ import (
 
 const (
 	resetTTL = 12 * time.Hour // 12 hours
+
+	// emailPattern matches the email addresses accepted for accounts
+	// and gallery invitations
+	emailPattern = "^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$"
 )
 
 // User accounts in database
@@ -120,7 +124,7 @@ func newUserValidator(udb UserDB, pepper string, hmac hash.HMAC) *userValidator
 	return &userValidator{
 		UserDB:     udb,
 		hmac:       hmac,
-		emailRegex: regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$"),
+		emailRegex: regexp.MustCompile(emailPattern),
 		pepper:     pepper,
 	}
 }
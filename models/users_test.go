@@ -6,7 +6,9 @@ import (
 	"time"
 )
 
-func testingUserService() (*UserService, error) {
+// testingServices connects to the test database with the services in
+// configs.
+func testingServices(configs ...ServicesConfig) (*Services, error) {
 	const (
 		host     = "host.docker.internal"
 		port     = 5432
@@ -17,15 +19,19 @@ func testingUserService() (*UserService, error) {
 
 	connectionInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
-	userService, err := NewUserService(connectionInfo)
+	configs = append([]ServicesConfig{WithGormDB("postgres", connectionInfo), WithDBLogMode(false)}, configs...)
+	return NewServices(configs...)
+}
+
+func testingUserService() (UserService, error) {
+	services, err := testingServices(WithUserService("test-pepper", "test-key"))
 	if err != nil {
 		return nil, err
 	}
-	userService.db.LogMode(false)
 
 	// Clear table
-	userService.DestructiveReset()
-	return userService, nil
+	services.DestructiveReset()
+	return services.User, nil
 }
 
 func TestCreateUser(t *testing.T) {
@@ -34,8 +40,9 @@ func TestCreateUser(t *testing.T) {
 		t.Fatal(err)
 	}
 	user := User{
-		Name:  "Michael Scott",
-		Email: "michael@dundermifflin.net",
+		Name:     "Michael Scott",
+		Email:    "michael@dundermifflin.net",
+		Password: "bestboss",
 	}
 	err = userService.Create(&user)
 	if err != nil {
@@ -58,8 +65,9 @@ func TestUpdateUser(t *testing.T) {
 		t.Fatal(err)
 	}
 	user := User{
-		Name:  "Michael Scott",
-		Email: "michael@dundermifflin.net",
+		Name:     "Michael Scott",
+		Email:    "michael@dundermifflin.net",
+		Password: "bestboss",
 	}
 	err = userService.Create(&user)
 	if err != nil {
@@ -70,7 +78,7 @@ func TestUpdateUser(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	dbUser, err := userService.ById(user.ID)
+	dbUser, err := userService.ByID(user.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,16 +93,17 @@ func TestDeleteUser(t *testing.T) {
 		t.Fatal(err)
 	}
 	user := User{
-		Name:  "Michael Scott",
-		Email: "michael@dundermifflin.net",
+		Name:     "Michael Scott",
+		Email:    "michael@dundermifflin.net",
+		Password: "bestboss",
 	}
 	err = userService.Create(&user)
 	if err != nil {
 		t.Fatal(err)
 	}
-	userService.Delete(1)
-	dbUser, err := userService.ById(user.ID)
-	if dbUser.Name != "" {
-		t.Errorf("Expected user to be deleted. Found %s", dbUser.Name)
+	userService.Delete(user.ID)
+	dbUser, err := userService.ByID(user.ID)
+	if err != ErrNotFound {
+		t.Errorf("Expected user to be deleted. Found %v", dbUser)
 	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"go-web-dev/email"
+	"go-web-dev/models"
+	"log"
+	"time"
+)
+
+const (
+	// commentNotifyInterval is how often the owners of galleries are
+	// emailed about new comments. Comments arriving in between are
+	// batched into a single email per owner.
+	commentNotifyInterval = 15 * time.Minute
+	galleryBaseURL        = "http://localhost:3000/galleries/"
+)
+
+// notifyComments emails the owners of galleries about the comments
+// they haven't been notified of yet, checking every interval. It runs
+// until the process exits. Comments are only marked as notified once
+// their email has been sent, so failed emails are retried.
+func notifyComments(commentService models.CommentService, emailClient *email.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		digests, err := commentService.Digests()
+		if err != nil {
+			log.Println("listing new comments:", err)
+			continue
+		}
+		for _, digest := range digests {
+			notices := make([]email.CommentNotice, len(digest.Comments))
+			for i, comment := range digest.Comments {
+				notices[i] = email.CommentNotice{
+					GalleryTitle: comment.GalleryTitle,
+					AuthorName:   comment.AuthorName,
+					Body:         comment.Body,
+					URL:          fmt.Sprintf("%s%d#image-%d", galleryBaseURL, comment.GalleryID, comment.ImageID),
+				}
+			}
+			err := emailClient.SendCommentsMessage(digest.Owner.Name, digest.Owner.Email, notices)
+			if err != nil {
+				log.Println("emailing new comments:", err)
+				continue
+			}
+			if err := commentService.MarkNotified(digest.IDs()...); err != nil {
+				log.Println("marking comments notified:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"go-web-dev/models"
+	"log"
+	"time"
+)
+
+// purgeTrash permanently deletes the galleries which have been in the
+// trash for longer than retention, checking every interval. It runs
+// until the process exits and does nothing when retention is zero.
+func purgeTrash(galleryService models.GalleryService, retention, interval time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := galleryService.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("purging trash:", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d galleries from the trash\n", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// visitPurgeInterval is how often the visits which no longer matter
+// for counting visitors are deleted.
+const visitPurgeInterval = time.Hour
+
+// purgeVisits deletes the visits recorded before today, checking every
+// interval. It runs until the process exits.
+func purgeVisits(analyticsService models.AnalyticsService, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := analyticsService.PurgeVisits(time.Now()); err != nil {
+			log.Println("purging visits:", err)
+		}
+		<-ticker.C
+	}
+}
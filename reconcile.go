@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"go-web-dev/models"
+	"log"
+)
+
+// reconcile runs reconcileImages with only the database and image
+// storage of appConfig, leaving out the services the server needs.
+func reconcile(appConfig AppConfig, mode string) error {
+	imageStorage, err := appConfig.Storage.Storage()
+	if err != nil {
+		return err
+	}
+	services, err := models.NewServices(
+		models.WithGormDB(appConfig.Database.Dialect(), appConfig.Database.ConnectionString()),
+		models.WithDBLogMode(false),
+		models.WithImageService(imageStorage, appConfig.Images.Limits(), appConfig.Images.VariantSizes...),
+	)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+	return reconcileImages(services.Image, mode)
+}
+
+// reconcileImages reports the images and files which no longer belong
+// to a live gallery, removing them when mode is "delete". Uploads in
+// progress may be reported too, so deleting is best done while the
+// server is stopped.
+func reconcileImages(imageService models.ImageService, mode string) error {
+	if mode != "report" && mode != "delete" {
+		return fmt.Errorf("unknown reconcile mode %q, expected report or delete", mode)
+	}
+	orphans, err := imageService.FindOrphans()
+	if err != nil {
+		return err
+	}
+	if orphans.Empty() {
+		log.Println("no orphaned images found")
+		return nil
+	}
+	for _, img := range orphans.Images {
+		log.Printf("image %d: %s (gallery %d no longer exists)\n", img.ID, img.Key(), img.GalleryID)
+	}
+	for _, key := range orphans.Keys {
+		log.Printf("file: %s (no matching image)\n", key)
+	}
+	if mode != "delete" {
+		log.Printf("found %d orphaned images and %d orphaned files, run with -reconcile=delete to remove them\n",
+			len(orphans.Images), len(orphans.Keys))
+		return nil
+	}
+	if err := imageService.DeleteOrphans(orphans); err != nil {
+		return err
+	}
+	log.Printf("removed %d orphaned images and %d orphaned files\n", len(orphans.Images), len(orphans.Keys))
+	return nil
+}
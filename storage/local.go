@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NewLocal returns a Storage which keeps files on disk below root.
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Local stores files on the local filesystem. It can only be used by
+// a single instance of the application.
+type Local struct {
+	root string
+}
+
+var _ Storage = &Local{}
+
+func (local *Local) Put(key string, r io.Reader) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so readers never see partial files
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := io.Copy(tmpFile, r); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func (local *Local) Get(key string) (io.ReadCloser, error) {
+	path, err := local.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+func (local *Local) Delete(key string) error {
+	path, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (local *Local) List(prefix string) ([]string, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	var keys []string
+	start := local.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		start = filepath.Join(local.root, filepath.FromSlash(prefix[:i]))
+	}
+	err := filepath.Walk(start, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(local.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// URL always returns an empty string as files on disk are streamed
+// through the application.
+func (local *Local) URL(key string) string {
+	return ""
+}
+
+func (local *Local) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(local.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLocalPutGetDelete(t *testing.T) {
+	local := NewLocal(t.TempDir())
+
+	if err := local.Put("galleries/1/a.jpg", strings.NewReader("pixels")); err != nil {
+		t.Fatal(err)
+	}
+	file, err := local.Get("galleries/1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(file)
+	file.Close()
+	if string(body) != "pixels" {
+		t.Errorf("Expected pixels. Received %s", body)
+	}
+
+	if err := local.Delete("galleries/1/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.Get("galleries/1/a.jpg"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Received %v", err)
+	}
+	if err := local.Delete("galleries/1/a.jpg"); err != nil {
+		t.Errorf("Expected deleting a missing file to succeed. Received %v", err)
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	local := NewLocal(t.TempDir())
+	for _, key := range []string{"galleries/1/a.jpg", "galleries/1/variants/320/a.jpg", "galleries/2/b.jpg"} {
+		if err := local.Put(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := local.List("galleries/1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "galleries/1/a.jpg,galleries/1/variants/320/a.jpg" {
+		t.Errorf("Expected the keys of gallery 1. Received %v", keys)
+	}
+
+	keys, err = local.List("galleries/3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Expected no keys. Received %v", keys)
+	}
+}
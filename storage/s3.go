@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm   = "AWS4-HMAC-SHA256"
+	s3Service     = "s3"
+	s3TimeFormat  = "20060102T150405Z"
+	s3DateFormat  = "20060102"
+	unsignedBody  = "UNSIGNED-PAYLOAD"
+	emptyBodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config describes how to reach an S3 compatible bucket, such as one
+// hosted by AWS or a MinIO server.
+type S3Config struct {
+	// Endpoint is the base URL of the API, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PresignTTL is how long URLs returned by URL remain valid. When
+	// zero URL returns an empty string and files are streamed through
+	// the application instead.
+	PresignTTL time.Duration
+}
+
+// NewS3 returns a Storage backed by an S3 compatible bucket. Requests
+// use path style addressing (endpoint/bucket/key) which every S3
+// implementation supports.
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("storage: S3 bucket is required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: time.Minute},
+		now:      time.Now,
+	}, nil
+}
+
+// S3 stores files in an S3 compatible bucket so that several
+// instances of the application can share them.
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+var _ Storage = &S3{}
+
+func (s3 *S3) Put(key string, r io.Reader) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s3.objectURL(key, nil), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(body))
+	hash := sha256.Sum256(body)
+	resp, err := s3.do(req, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s3 *S3) Get(key string) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+	req, err := http.NewRequest(http.MethodGet, s3.objectURL(key, nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s3.do(req, emptyBodyHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s3 *S3) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrInvalidKey
+	}
+	req, err := http.NewRequest(http.MethodDelete, s3.objectURL(key, nil), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s3.do(req, emptyBodyHash)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s3 *S3) List(prefix string) ([]string, error) {
+	if !validPrefix(prefix) {
+		return nil, ErrInvalidKey
+	}
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, s3.objectURL("", query), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s3.do(req, emptyBodyHash)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// URL returns a presigned GET URL for key when a PresignTTL is
+// configured.
+func (s3 *S3) URL(key string) string {
+	if s3.config.PresignTTL <= 0 || !ValidKey(key) {
+		return ""
+	}
+	now := s3.now().UTC()
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s3.config.AccessKey+"/"+s3.scope(now))
+	query.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	query.Set("X-Amz-Expires", fmt.Sprint(int(s3.config.PresignTTL/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	req, err := http.NewRequest(http.MethodGet, s3.objectURL(key, query), nil)
+	if err != nil {
+		return ""
+	}
+	signature := s3.signature(req, []string{"host"}, unsignedBody, now)
+	return req.URL.String() + "&X-Amz-Signature=" + signature
+}
+
+// do signs and sends req, translating error responses into errors.
+func (s3 *S3) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s3.sign(req, payloadHash, s3.now().UTC())
+	resp, err := s3.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("storage: S3 %s %s returned %s: %s", req.Method, req.URL.Path, resp.Status, message)
+}
+
+func (s3 *S3) objectURL(key string, query url.Values) string {
+	objectURL := *s3.endpoint
+	objectURL.Path = strings.TrimSuffix(s3.endpoint.Path, "/")
+	if s3.config.Bucket != "" {
+		objectURL.Path += "/" + s3.config.Bucket
+	}
+	objectURL.Path += "/" + key
+	objectURL.RawPath = uriEncode(objectURL.Path, false)
+	objectURL.RawQuery = canonicalQuery(query)
+	return objectURL.String()
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s3 *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Date", now.Format(s3TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var signedHeaders []string
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "content-md5" || name == "range" {
+			signedHeaders = append(signedHeaders, name)
+		}
+	}
+	signedHeaders = append(signedHeaders, "host")
+	sort.Strings(signedHeaders)
+
+	signature := s3.signature(req, signedHeaders, payloadHash, now)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s3.config.AccessKey, s3.scope(now), strings.Join(signedHeaders, ";"), signature))
+}
+
+func (s3 *S3) signature(req *http.Request, signedHeaders []string, payloadHash string, now time.Time) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3TimeFormat),
+		s3.scope(now),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s3.config.SecretKey), now.Format(s3DateFormat))
+	key = hmacSHA256(key, s3.config.Region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s3 *S3) scope(now time.Time) string {
+	return strings.Join([]string{now.Format(s3DateFormat), s3.config.Region, s3Service, "aws4_request"}, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key as required for signing.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent encodes every byte except the unreserved
+// characters of RFC 3986, optionally leaving slashes untouched.
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound   = errors.New("storage: file not found")
+	ErrInvalidKey = errors.New("storage: key is not valid")
+)
+
+// Storage persists files under slash separated keys such as
+// "galleries/1/photo.jpg".
+type Storage interface {
+	// Put creates or replaces the file stored under key
+	Put(key string, r io.Reader) error
+	// Get opens the file stored under key. ErrNotFound is returned
+	// when no such file exists.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the file stored under key. Deleting a file that
+	// does not exist is not an error.
+	Delete(key string) error
+	// List returns the keys of every file stored under prefix
+	List(prefix string) ([]string, error)
+	// URL returns a location the file can be fetched from directly by
+	// a client, or an empty string when it must be streamed through Get.
+	URL(key string) string
+}
+
+// ValidKey reports whether key is a relative, slash separated path
+// made only of named segments. Keys which could escape the storage
+// root, such as "../x" or "/etc/passwd", are not valid.
+func ValidKey(key string) bool {
+	if key == "" || strings.ContainsAny(key, "\\\x00") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validPrefix reports whether prefix is empty or a valid key, allowing
+// for a trailing slash.
+func validPrefix(prefix string) bool {
+	return prefix == "" || ValidKey(strings.TrimSuffix(prefix, "/"))
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	valid := []string{
+		"galleries/1/0123456789abcdef0123456789abcdef.jpg",
+		"galleries/1/variants/320/0123456789abcdef0123456789abcdef.png",
+		"a..b",
+	}
+	for _, key := range valid {
+		if !ValidKey(key) {
+			t.Errorf("Expected %q to be valid", key)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"..",
+		"../config/config.json",
+		"galleries/1/../../../etc/passwd",
+		"galleries/1/..",
+		"/etc/passwd",
+		"./galleries/1/a.jpg",
+		"galleries//a.jpg",
+		"galleries/1/",
+		`galleries\..\..\etc\passwd`,
+		"galleries/1/a.jpg\x00.png",
+	}
+	for _, key := range invalid {
+		if ValidKey(key) {
+			t.Errorf("Expected %q to be invalid", key)
+		}
+	}
+}
+
+func TestLocalRejectsTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "images")
+	secret := filepath.Join(dir, "secret.txt")
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local := NewLocal(root)
+
+	for _, key := range []string{"../secret.txt", "galleries/../../secret.txt", secret} {
+		if _, err := local.Get(key); err != ErrInvalidKey {
+			t.Errorf("Get(%q): expected ErrInvalidKey. Received %v", key, err)
+		}
+		if err := local.Put(key, strings.NewReader("overwritten")); err != ErrInvalidKey {
+			t.Errorf("Put(%q): expected ErrInvalidKey. Received %v", key, err)
+		}
+		if err := local.Delete(key); err != ErrInvalidKey {
+			t.Errorf("Delete(%q): expected ErrInvalidKey. Received %v", key, err)
+		}
+	}
+	if _, err := local.List("../"); err != ErrInvalidKey {
+		t.Errorf("List: expected ErrInvalidKey. Received %v", err)
+	}
+
+	contents, err := ioutil.ReadFile(secret)
+	if err != nil {
+		if os.IsNotExist(err) {
+			t.Fatal("Expected file outside of the storage root to survive")
+		}
+		t.Fatal(err)
+	}
+	if string(contents) != "secret" {
+		t.Errorf("Expected file outside of the storage root to be untouched. Found %s", contents)
+	}
+}
@@ -21,12 +21,14 @@ const (
 type Alert struct {
 	Level   string
 	Message string
+	Details []string
 }
 
 type Data struct {
-	Alert *Alert
-	User  *models.User
-	Yield interface{}
+	Alert      *Alert
+	User       *models.User
+	Pagination *Pagination
+	Yield      interface{}
 }
 
 func (data *Data) SetAlert(err error) {
@@ -35,6 +37,9 @@ func (data *Data) SetAlert(err error) {
 			Level:   AlertLevelError,
 			Message: publicErr.Public(),
 		}
+		if detailedErr, ok := err.(DetailedError); ok {
+			data.Alert.Details = detailedErr.Details()
+		}
 	} else {
 		log.Println(err)
 		data.Alert = &Alert{
@@ -56,6 +61,13 @@ type PublicError interface {
 	Public() string
 }
 
+// DetailedError is a PublicError which also lists individual problems,
+// such as each file rejected from an upload.
+type DetailedError interface {
+	PublicError
+	Details() []string
+}
+
 func persistAlert(w http.ResponseWriter, alert Alert) {
 	expiresAt := time.Now().Add(alertCookieTTLMin * time.Minute)
 	level := http.Cookie{
@@ -0,0 +1,70 @@
+package views
+
+import (
+	"go-web-dev/models"
+	"net/url"
+)
+
+// Pagination is the state of a paged listing, rendered by the "sorter"
+// and "pager" templates of the layout. Pages are addressed by cursors,
+// see models.PageQuery, and keep the listing's Sort and Order.
+type Pagination struct {
+	// Path is where the listing is served
+	Path string
+	// Params are kept by the links to every page, e.g. filters
+	Params url.Values
+	// Sorts are the orders the listing can be sorted in, if any
+	Sorts []string
+	Sort  string
+	Order string
+	Next  string
+	Prev  string
+}
+
+// NextURL links to the following page, or is empty on the last page.
+func (pagination *Pagination) NextURL() string {
+	if pagination.Next == "" {
+		return ""
+	}
+	return pagination.url(pagination.Sort, pagination.Order, "after", pagination.Next)
+}
+
+// PrevURL links to the preceding page, or is empty on the first page.
+func (pagination *Pagination) PrevURL() string {
+	if pagination.Prev == "" {
+		return ""
+	}
+	return pagination.url(pagination.Sort, pagination.Order, "before", pagination.Prev)
+}
+
+// SortURL links to the first page of the listing sorted by sort. The
+// current sort links to the opposite order.
+func (pagination *Pagination) SortURL(sort string) string {
+	order := ""
+	if sort == pagination.Sort {
+		order = models.OrderAsc
+		if pagination.Order == models.OrderAsc {
+			order = models.OrderDesc
+		}
+	}
+	return pagination.url(sort, order, "", "")
+}
+
+func (pagination *Pagination) url(sort, order, cursorParam, cursor string) string {
+	params := url.Values{}
+	for key, values := range pagination.Params {
+		params[key] = values
+	}
+	// listings with a single order don't need to keep it
+	if sort != "" && len(pagination.Sorts) > 0 {
+		params.Set("sort", sort)
+	}
+	if order != "" && len(pagination.Sorts) > 0 {
+		params.Set("order", order)
+	}
+	if cursorParam != "" {
+		params.Set(cursorParam, cursor)
+	}
+	urlObject := url.URL{Path: pagination.Path, RawQuery: params.Encode()}
+	return urlObject.String()
+}